@@ -1,7 +1,5 @@
 package proxyprotocol
 
-import "io"
-
 type buffer struct {
 	data []byte
 	size int
@@ -28,8 +26,5 @@ func (b *buffer) Write(p []byte) (int, error) {
 	}
 	return len(p), nil
 }
-func (b *buffer) WriteTo(w io.Writer) (int64, error) {
-	n, err := w.Write(b.data[:b.size])
-	return int64(n), err
-}
-func (b *buffer) Len() int { return b.size }
+func (b *buffer) Bytes() []byte { return b.data[:b.size] }
+func (b *buffer) Len() int      { return b.size }
@@ -0,0 +1,75 @@
+package proxyprotocol
+
+import "net"
+
+// Builder constructs a HeaderV2, recording the first error encountered so
+// methods can be chained and checked once with Build.
+//
+// The address family and protocol are determined by the address types passed to Proxy.
+type Builder struct {
+	h   HeaderV2
+	err error
+}
+
+// NewBuilder will create a new Builder for a LOCAL header with no TLVs.
+func NewBuilder() *Builder { return &Builder{} }
+
+// Proxy sets the command to CmdProxy with the given source and destination addresses.
+func (b *Builder) Proxy(src, dst net.Addr) *Builder {
+	b.h.Command = CmdProxy
+	b.h.Src = src
+	b.h.Dest = dst
+	return b
+}
+
+// Local sets the command to CmdLocal, clearing any addresses.
+func (b *Builder) Local() *Builder {
+	b.h.Command = CmdLocal
+	b.h.Src = nil
+	b.h.Dest = nil
+	return b
+}
+
+// WithALPN sets the PP2TypeALPN TLV, replacing any existing value.
+func (b *Builder) WithALPN(proto string) *Builder {
+	return b.set(b.h.SetTLV(PP2TypeALPN, []byte(proto)))
+}
+
+// WithAuthority sets the PP2TypeAuthority TLV, replacing any existing value.
+func (b *Builder) WithAuthority(host string) *Builder {
+	return b.set(b.h.SetAuthority(host))
+}
+
+// WithUniqueID sets the PP2TypeUniqueID TLV, replacing any existing value.
+func (b *Builder) WithUniqueID(id []byte) *Builder {
+	return b.set(b.h.SetUniqueID(id))
+}
+
+// WithTLV appends a TLV of type t with value v.
+func (b *Builder) WithTLV(t PP2Type, v []byte) *Builder {
+	return b.set(b.h.AddTLV(t, v))
+}
+
+func (b *Builder) set(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// Build returns the header, or the first error encountered while building it or from Validate.
+//
+// The Builder may continue to be used after Build without affecting the returned header.
+func (b *Builder) Build() (*HeaderV2, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	err := b.h.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h := b.h
+	h.TLVs = append([]TLV(nil), b.h.TLVs...)
+	return &h, nil
+}
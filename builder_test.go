@@ -0,0 +1,50 @@
+package proxyprotocol
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleBuilder() {
+	h, err := NewBuilder().
+		Proxy(
+			&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+			&net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 443},
+		).
+		WithALPN("h2").
+		WithAuthority("example.com").
+		Build()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(h)
+	// Output: PROXY v2 PROXY TCP4 192.168.0.1:1234 -> 192.168.0.2:443 [ALPN Authority]
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder().WithTLV(PP2TypeNOOP, nil)
+	h, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, &HeaderV2{TLVs: []TLV{{Type: PP2TypeNOOP}}}, h)
+
+	b.WithTLV(PP2TypeNOOP, []byte{0})
+	assert.Len(t, h.TLVs, 1, "unaffected by later changes")
+
+	_, err = NewBuilder().WithUniqueID(make([]byte, 129)).WithALPN("h2").Build()
+	assert.EqualError(t, err, "unique ID too long")
+
+	_, err = NewBuilder().WithAuthority(strings.Repeat("a", 0x10000)).Build()
+	assert.Error(t, err)
+
+	_, err = NewBuilder().Proxy(&net.TCPAddr{IP: net.ParseIP("::1")}, &net.UDPAddr{IP: net.ParseIP("::1")}).Build()
+	assert.Error(t, err, "mismatched address types")
+
+	h, err = NewBuilder().Proxy(&net.TCPAddr{IP: net.ParseIP("::1")}, &net.TCPAddr{IP: net.ParseIP("::1")}).Local().Build()
+	assert.NoError(t, err)
+	assert.Equal(t, &HeaderV2{}, h)
+}
@@ -0,0 +1,41 @@
+package proxyprotocol
+
+import "encoding/binary"
+
+// PP2TypeAWS is the custom TLV type used by AWS Network Load Balancers. The first byte
+// of the value is a sub-type, followed by the sub-type's value.
+const PP2TypeAWS PP2Type = 0xEA
+
+// awsSubTypeVPCEID is the AWS sub-type containing the VPC endpoint ID as an ASCII string.
+const awsSubTypeVPCEID = 0x01
+
+// AWSVPCEndpointID returns the VPC endpoint ID (e.g. "vpce-08d2bf15fac5001c9") sent by an
+// AWS Network Load Balancer for connections through AWS PrivateLink.
+func (h HeaderV2) AWSVPCEndpointID() (string, bool) {
+	// AWS may send several TLVs of its type, one per sub-type
+	for _, v := range FindAllTLV(h, PP2TypeAWS) {
+		if len(v) > 0 && v[0] == awsSubTypeVPCEID {
+			return string(v[1:]), true
+		}
+	}
+	return "", false
+}
+
+// PP2TypeAzure is the custom TLV type used by Azure Private Link services. The first byte
+// of the value is a sub-type, followed by the sub-type's value.
+const PP2TypeAzure PP2Type = 0xEE
+
+// azureSubTypeLinkID is the Azure sub-type containing the private endpoint LINKID.
+const azureSubTypeLinkID = 0x01
+
+// AzureLinkID returns the LINKID of the private endpoint sent by an Azure Private Link service.
+//
+// Azure encodes the LINKID as a 4-byte little-endian integer.
+func (h HeaderV2) AzureLinkID() (uint32, bool) {
+	for _, v := range FindAllTLV(h, PP2TypeAzure) {
+		if len(v) == 5 && v[0] == azureSubTypeLinkID {
+			return binary.LittleEndian.Uint32(v[1:]), true
+		}
+	}
+	return 0, false
+}
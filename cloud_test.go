@@ -0,0 +1,40 @@
+package proxyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderV2_AWSVPCEndpointID(t *testing.T) {
+	var h HeaderV2
+	_, ok := h.AWSVPCEndpointID()
+	assert.False(t, ok)
+
+	h.TLVs = []TLV{
+		{Type: PP2TypeAWS, Value: []byte{0x02, 'x'}}, // unknown sub-type
+		{Type: PP2TypeAWS},
+		{Type: PP2TypeAWS, Value: append([]byte{0x01}, "vpce-08d2bf15fac5001c9"...)},
+	}
+	id, ok := h.AWSVPCEndpointID()
+	assert.True(t, ok)
+	assert.Equal(t, "vpce-08d2bf15fac5001c9", id)
+
+	assert.Equal(t, "AWS", PP2TypeAWS.String())
+}
+
+func TestHeaderV2_AzureLinkID(t *testing.T) {
+	var h HeaderV2
+	_, ok := h.AzureLinkID()
+	assert.False(t, ok)
+
+	h.TLVs = []TLV{
+		{Type: PP2TypeAzure, Value: []byte{0x01, 0x01}}, // short
+		{Type: PP2TypeAzure, Value: []byte{0x01, 0x78, 0x56, 0x34, 0x12}},
+	}
+	id, ok := h.AzureLinkID()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x12345678), id)
+
+	assert.Equal(t, "Azure", PP2TypeAzure.String())
+}
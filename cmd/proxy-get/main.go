@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/mastercactapus/proxyprotocol"
 )
@@ -23,7 +24,7 @@ func parseHostPort(s string) (net.IP, int, error) {
 		return nil, 0, fmt.Errorf("invalid port '%s': %w", portStr, err)
 	}
 	if srcPort < 1 || srcPort > 65535 {
-		return nil, 0, fmt.Errorf("invalid port '%d': must be between 1-65535")
+		return nil, 0, fmt.Errorf("invalid port '%d': must be between 1-65535", srcPort)
 	}
 
 	srcIP := net.ParseIP(addr)
@@ -63,6 +64,7 @@ func main() {
 	dst := flag.String("dst", "127.0.1.1:456", "Destination address to use.")
 	dstType := flag.String("dst-type", "tcp", "Destination address type (can be tcp, udp, or unix -- v2 only).")
 	local := flag.Bool("local", false, "Indicate local request (v2 only).")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout for the request, including writing the PROXY header. Set to `0` to disable.")
 	flag.Parse()
 
 	if *version == 1 {
@@ -72,63 +74,38 @@ func main() {
 
 	srcAddr := parseAddr("src", *srcType, *src)
 	dstAddr := parseAddr("dst", *dstType, *dst)
+	var hdr proxyprotocol.Header
 	switch *version {
 	case 1:
-		http.DefaultClient.Transport = &http.Transport{
-			Dial: func(n, addr string) (net.Conn, error) {
-				c, err := net.Dial(n, addr)
-				if err != nil {
-					return nil, fmt.Errorf("dial: %w", err)
-				}
-				s := srcAddr.(*net.TCPAddr)
-				d := dstAddr.(*net.TCPAddr)
-				hdr := &proxyprotocol.HeaderV1{
-					SrcIP:    s.IP,
-					SrcPort:  s.Port,
-					DestIP:   d.IP,
-					DestPort: d.Port,
-				}
-
-				_, err = hdr.WriteTo(c)
-				if err != nil {
-					c.Close()
-					return nil, fmt.Errorf("write v1 header: %w", err)
-				}
-
-				return c, nil
-			},
+		s := srcAddr.(*net.TCPAddr)
+		d := dstAddr.(*net.TCPAddr)
+		hdr = &proxyprotocol.HeaderV1{
+			SrcIP:    s.IP,
+			SrcPort:  s.Port,
+			DestIP:   d.IP,
+			DestPort: d.Port,
 		}
 	case 2:
-		http.DefaultClient.Transport = &http.Transport{
-			Dial: func(n, addr string) (net.Conn, error) {
-				c, err := net.Dial(n, addr)
-				if err != nil {
-					return nil, fmt.Errorf("dial: %w", err)
-				}
-
-				hdr := &proxyprotocol.HeaderV2{
-					Command: proxyprotocol.CmdProxy,
-					Src:     srcAddr,
-					Dest:    dstAddr,
-				}
-				if *local {
-					hdr.Command = proxyprotocol.CmdLocal
-				}
-
-				_, err = hdr.WriteTo(c)
-				if err != nil {
-					c.Close()
-					return nil, fmt.Errorf("write v2 header: %w", err)
-				}
-				return c, nil
-			},
+		h := &proxyprotocol.HeaderV2{
+			Command: proxyprotocol.CmdProxy,
+			Src:     srcAddr,
+			Dest:    dstAddr,
 		}
+		if *local {
+			h.Command = proxyprotocol.CmdLocal
+		}
+		hdr = h
 	case 0:
 		// do nothing
 	default:
 		log.Fatal("Invalid value for -v flag.")
 	}
 
+	http.DefaultClient.Timeout = *timeout
+	if hdr != nil {
+		http.DefaultClient.Transport = proxyprotocol.NewTransport(nil, func(string) proxyprotocol.Header { return hdr })
+	}
+
 	resp, err := http.Get(flag.Arg(0))
 	if err != nil {
 		log.Fatal("ERROR: ", err)
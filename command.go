@@ -1,5 +1,7 @@
 package proxyprotocol
 
+import "fmt"
+
 // Cmd indicates the PROXY command being used.
 type Cmd byte
 
@@ -10,3 +12,14 @@ const (
 	// CmdProxy the connection was established on behalf of another node, and reflects the original connection endpoints.
 	CmdProxy Cmd = 0x01
 )
+
+// String returns "LOCAL" or "PROXY" for known commands.
+func (c Cmd) String() string {
+	switch c {
+	case CmdLocal:
+		return "LOCAL"
+	case CmdProxy:
+		return "PROXY"
+	}
+	return fmt.Sprintf("Cmd(0x%x)", byte(c))
+}
@@ -0,0 +1,25 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmd(t *testing.T) {
+	assert.Equal(t, "LOCAL", CmdLocal.String())
+	assert.Equal(t, "PROXY", CmdProxy.String())
+	assert.Equal(t, "Cmd(0x2)", Cmd(2).String())
+
+	parse := func(verCmd byte) error {
+		data := append(append([]byte{}, sigV2...), verCmd, 0, 0, 0)
+		_, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		return err
+	}
+	assert.NoError(t, parse(0x20), "LOCAL")
+	assert.NoError(t, parse(0x21), "PROXY")
+	assert.IsType(t, &InvalidHeaderErr{}, parse(0x22), "unknown command")
+	assert.IsType(t, &InvalidHeaderErr{}, parse(0x2f), "unknown command")
+}
@@ -2,6 +2,9 @@ package proxyprotocol
 
 import (
 	"bufio"
+	"crypto/subtle"
+	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -16,10 +19,57 @@ type Conn struct {
 	deadline     time.Time
 	nextDeadline time.Time
 	hdr          Header
+	hdrs         []Header
+	preamble     []byte
+	optional     bool
+	maxHeaders   int
+	opts         ParseOptions
+	validators   []Validator
+	onHeader     func(Header)
+	onError      func(error)
+
+	// pool, if set, provides the HeaderV2 to parse into. It is returned to the pool by Close
+	// if the header was never handed out, including to validators and onHeader (see releaseHeader).
+	pool      *sync.Pool
+	pooled    *HeaderV2
+	handedOut bool
+	released  bool
+	mx        sync.Mutex
 
 	local, remote net.Addr
 }
 
+// ErrHeaderTimeout is matched (using errors.Is) by the error returned by Conn when the PROXY header is not
+// received before the deadline, distinguishing a missing header from an invalid one.
+//
+// The returned error implements net.Error, reporting a timeout, and wraps the error from reading the header,
+// so the data read before the deadline is available with errors.As and an *InvalidHeaderErr.
+var ErrHeaderTimeout error = &headerTimeoutErr{}
+
+type headerTimeoutErr struct{ err error }
+
+func (*headerTimeoutErr) Error() string   { return "timeout waiting for PROXY header" }
+func (*headerTimeoutErr) Timeout() bool   { return true }
+func (*headerTimeoutErr) Temporary() bool { return true }
+
+// Unwrap returns the error from reading the header.
+func (e *headerTimeoutErr) Unwrap() error { return e.err }
+
+// Is reports whether target is ErrHeaderTimeout.
+func (*headerTimeoutErr) Is(target error) bool { return target == ErrHeaderTimeout }
+
+// isTimeout reports whether err, or the error within an InvalidHeaderErr, is a net.Error timeout.
+func isTimeout(err error) bool {
+	if ihe, ok := err.(*InvalidHeaderErr); ok {
+		err = ihe.error
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// errHeaderReleased is returned for the header of a Conn after it was returned to its pool by Close.
+var errHeaderReleased = errors.New("PROXY header released by Close")
+
 // NewConn will wrap an existing net.Conn using `deadline` to receive the header.
 func NewConn(c net.Conn, deadline time.Time) *Conn {
 	return &Conn{
@@ -29,28 +79,233 @@ func NewConn(c net.Conn, deadline time.Time) *Conn {
 	}
 }
 
+// DefaultWrapTimeout is the amount of time allowed to receive the PROXY header for connections
+// wrapped with WrapConn. Zero (the default) means no timeout.
+var DefaultWrapTimeout time.Duration
+
+// WrapConn will wrap an existing net.Conn, allowing DefaultWrapTimeout to receive the header.
+//
+// Any net.Conn may be wrapped, including a *tls.Conn when the PROXY header is sent
+// inside the TLS session. Data following the header is buffered by the returned Conn.
+func WrapConn(c net.Conn) *Conn { return WrapConnTimeout(c, DefaultWrapTimeout) }
+
+// WrapConnTimeout will wrap an existing net.Conn, allowing t to receive the header.
+//
+// The timeout t is always used instead of DefaultWrapTimeout; zero means no timeout.
+func WrapConnTimeout(c net.Conn, t time.Duration) *Conn {
+	if t == 0 {
+		return NewConn(c, time.Time{})
+	}
+	return NewConn(c, time.Now().Add(t))
+}
+
+// WrapConnOptional will wrap an existing net.Conn that may or may not begin with a PROXY header,
+// allowing DefaultWrapTimeout to receive it.
+//
+// The start of the connection is checked immediately, peeking only as many bytes as needed to rule out
+// a V1 or V2 signature. If a header is present, it is parsed and any error is returned. Otherwise the
+// returned Conn uses the addresses of c and all peeked bytes remain available to Read.
+func WrapConnOptional(c net.Conn) (net.Conn, error) {
+	conn := WrapConn(c)
+	conn.optional = true
+	_, err := conn.ProxyHeader()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
 // ProxyHeader will return the PROXY header received on the current connection.
+//
+// If the header was optional (see PolicyOptional) and none was sent, a nil Header and error are returned.
 func (c *Conn) ProxyHeader() (Header, error) {
 	c.once.Do(c.parse)
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.released {
+		return nil, errHeaderReleased
+	}
+	c.handedOut = true
 	return c.hdr, c.err
 }
 
+// ProxyHeaders will return all PROXY headers received on the current connection, in the order received.
+//
+// More than one header is only read if enabled by the Listener (see SetMaxHeaders), in which case
+// ProxyHeader returns the last (innermost) one.
+func (c *Conn) ProxyHeaders() ([]Header, error) {
+	c.once.Do(c.parse)
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.released {
+		return nil, errHeaderReleased
+	}
+	c.handedOut = true
+	return c.hdrs, c.err
+}
+
+// Peek will return the PROXY header received on the current connection, reading it if necessary,
+// along with the buffered reader positioned at the first byte of application data.
+//
+// The returned reader is the one used by Read, so data is never lost or returned twice: bytes read
+// from it are consumed and will not be returned by Read, and vice versa. This allows handing the
+// connection's data to another library without an extra copy.
+func (c *Conn) Peek() (Header, *bufio.Reader, error) {
+	c.once.Do(c.parse)
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.released {
+		return nil, nil, errHeaderReleased
+	}
+	c.handedOut = true
+	return c.hdr, c.r, nil
+}
+
+// ProxyHeaderPresent reports whether a PROXY header was successfully received, reading it if necessary.
+//
+// It is false if the header was optional and not sent, or was invalid. Note that a valid header may
+// still not provide addresses (e.g. a V2 LOCAL or V1 UNKNOWN header), in which case LocalAddr and
+// RemoteAddr return those of the underlying connection.
+func (c *Conn) ProxyHeaderPresent() bool {
+	h, err := c.ProxyHeader()
+	return err == nil && h != nil
+}
+
+// readHeader reads the header if necessary, returning any error, without handing out the header.
+func (c *Conn) readHeader() error {
+	c.once.Do(c.parse)
+	return c.err
+}
+
 func (c *Conn) parse() {
-	// use earliest deadline
-	if c.nextDeadline.IsZero() || c.nextDeadline.Before(c.deadline) {
+	defer func() {
+		switch {
+		case c.err != nil && c.onError != nil:
+			c.onError(c.err)
+		case c.err == nil && c.hdr != nil && c.onHeader != nil:
+			c.markHandedOut()
+			c.onHeader(c.hdr)
+		}
+	}()
+	defer func() {
+		if isTimeout(c.err) {
+			c.err = &headerTimeoutErr{err: c.err}
+		}
+	}()
+
+	// Use the header deadline only if it is earlier than one set by the caller (which is already
+	// applied to the underlying conn), restoring the caller's afterwards. Without a header deadline,
+	// any deadline set directly on the underlying conn is left untouched.
+	if !c.deadline.IsZero() && (c.nextDeadline.IsZero() || c.deadline.Before(c.nextDeadline)) {
 		c.Conn.SetReadDeadline(c.deadline)
-		defer c.Conn.SetReadDeadline(c.nextDeadline)
-	} else {
-		c.Conn.SetReadDeadline(c.nextDeadline)
+		defer func() { c.Conn.SetReadDeadline(c.nextDeadline) }()
 	}
 
-	c.hdr, c.err = Parse(c.r)
+	if len(c.preamble) > 0 {
+		buf := make([]byte, len(c.preamble))
+		n, err := io.ReadFull(c.r, buf)
+		if err != nil {
+			c.err = &InvalidHeaderErr{Read: buf[:n], error: err}
+			return
+		}
+		if subtle.ConstantTimeCompare(buf, c.preamble) != 1 {
+			c.err = &InvalidHeaderErr{Read: buf, error: errors.New("invalid preamble")}
+			return
+		}
+	}
+
+	if c.optional {
+		v, err := Detect(c.r)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if v == 0 {
+			// no header, use real addresses
+			return
+		}
+	}
+
+	if c.maxHeaders > 1 {
+		c.hdrs, c.err = parseChain(c.r, c.maxHeaders, c.opts)
+	} else if c.pool != nil {
+		v2 := c.pool.Get().(*HeaderV2)
+		c.hdr, c.err = parseInto(c.r, c.opts, nil, v2)
+		if c.hdr == Header(v2) {
+			c.pooled = v2
+		} else {
+			c.pool.Put(v2)
+		}
+		c.hdrs = []Header{c.hdr}
+	} else {
+		c.hdr, c.err = ParseWithOptions(c.r, c.opts)
+		c.hdrs = []Header{c.hdr}
+	}
 	if c.err != nil {
+		c.hdrs = nil
+		return
+	}
+	c.hdr = c.hdrs[len(c.hdrs)-1]
+
+	if len(c.validators) > 0 {
+		// validators may keep the header
+		c.markHandedOut()
+	}
+	if err := ValidateHeader(c.hdr, c.validators...); err != nil {
+		c.err = &InvalidHeaderErr{error: err, Version: c.hdr.Version()}
+		c.hdr, c.hdrs = nil, nil
 		return
 	}
 
-	c.local = c.hdr.DestAddr()
-	c.remote = c.hdr.SrcAddr()
+	// use the innermost header providing addresses (e.g. not LOCAL)
+	for i := len(c.hdrs) - 1; i >= 0; i-- {
+		if c.hdrs[i].SrcAddr() != nil {
+			c.local = c.hdrs[i].DestAddr()
+			c.remote = c.hdrs[i].SrcAddr()
+			break
+		}
+	}
+
+	// a UNIX destination can't be used as the local address of an IP socket
+	if _, ok := c.local.(*net.UnixAddr); ok {
+		if _, ok := c.Conn.LocalAddr().(*net.UnixAddr); !ok {
+			c.local = nil
+		}
+	}
+}
+
+// Close closes the underlying net.Conn.
+//
+// For connections accepted by a Listener that read the header during Accept, a header that was never
+// retrieved (with ProxyHeader, ProxyHeaders, or Peek) or passed to a validator or OnHeader callback is
+// reused for later connections. ProxyHeader, ProxyHeaders, and Peek then return an error. LocalAddr and
+// RemoteAddr are not affected.
+func (c *Conn) Close() error {
+	c.releaseHeader()
+	return c.Conn.Close()
+}
+
+// markHandedOut prevents the header from being returned to its pool.
+func (c *Conn) markHandedOut() {
+	c.mx.Lock()
+	c.handedOut = true
+	c.mx.Unlock()
+}
+
+// releaseHeader returns a pooled header to its pool, unless it has been handed out.
+func (c *Conn) releaseHeader() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.pooled == nil || c.handedOut {
+		return
+	}
+	c.hdr, c.hdrs = nil, nil
+	c.released = true
+	c.pool.Put(c.pooled)
+	c.pooled = nil
 }
 
 // SetDeadline calls SetDeadline on the underlying net.Conn.
@@ -66,6 +321,10 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 }
 
 // RemoteAddr returns the remote network address provided by the PROXY header.
+//
+// The address of the underlying connection is returned if the header is invalid, was optional and
+// not sent, or does not provide addresses, as with a V2 LOCAL command (regardless of any address
+// data sent with it) or a V1 UNKNOWN protocol.
 func (c *Conn) RemoteAddr() net.Addr {
 	c.once.Do(c.parse)
 	if c.err != nil || c.remote == nil {
@@ -75,6 +334,11 @@ func (c *Conn) RemoteAddr() net.Addr {
 }
 
 // LocalAddr returns the local network address provided by the PROXY header.
+//
+// The address of the underlying connection is used under the same conditions as RemoteAddr, and
+// also if the header provides a UNIX destination address but the underlying connection is not a
+// UNIX socket, since servers may expect LocalAddr to match the type of socket they listen on (e.g.
+// *net.TCPAddr for a TCP listener). The header's address is still available from ProxyHeader.
 func (c *Conn) LocalAddr() net.Addr {
 	c.once.Do(c.parse)
 	if c.err != nil || c.local == nil {
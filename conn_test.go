@@ -1,8 +1,17 @@
 package proxyprotocol
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"net"
 	"testing"
 	"time"
@@ -197,3 +206,343 @@ func TestNewConnV1(t *testing.T) {
 	)
 
 }
+
+func TestWrapConn_Timeout(t *testing.T) {
+	defer func(d time.Duration) { DefaultWrapTimeout = d }(DefaultWrapTimeout)
+
+	check := func(name string, wrap func(net.Conn) *Conn, timeout time.Duration) {
+		t.Run(name, func(t *testing.T) {
+			src, dst := net.Pipe()
+			defer src.Close()
+			defer dst.Close()
+
+			start := time.Now()
+			c := wrap(dst)
+			if timeout == 0 {
+				assert.True(t, c.deadline.IsZero(), "deadline")
+				return
+			}
+			assert.False(t, c.deadline.Before(start.Add(timeout)), "deadline too early")
+			assert.True(t, c.deadline.Before(time.Now().Add(timeout)), "deadline too late")
+
+			_, err := c.ProxyHeader()
+			assert.True(t, errors.Is(err, ErrHeaderTimeout), "expected ErrHeaderTimeout, got %v", err)
+			assertTimedOutAt(t, c.deadline)
+		})
+	}
+
+	DefaultWrapTimeout = 0
+	check("no-default", WrapConn, 0)
+
+	DefaultWrapTimeout = 50 * time.Millisecond
+	check("default", WrapConn, 50*time.Millisecond)
+
+	DefaultWrapTimeout = time.Hour
+	check("override", func(c net.Conn) *Conn { return WrapConnTimeout(c, 50*time.Millisecond) }, 50*time.Millisecond)
+	check("override-none", func(c net.Conn) *Conn { return WrapConnTimeout(c, 0) }, 0)
+}
+
+// timeoutSlack is how late a deadline may be reported, allowing for scheduling delays.
+const timeoutSlack = 200 * time.Millisecond
+
+// assertTimedOutAt checks that a timeout was reported at deadline, allowing for timeoutSlack.
+func assertTimedOutAt(t *testing.T, deadline time.Time) {
+	t.Helper()
+	now := time.Now()
+	assert.False(t, now.Before(deadline), "timed out early")
+	assert.True(t, now.Sub(deadline) < timeoutSlack, "timed out late: %v after deadline", now.Sub(deadline))
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func TestWrapConn_TLS(t *testing.T) {
+	cfg := testTLSConfig(t)
+	src, dst := net.Pipe()
+	defer src.Close()
+	defer dst.Close()
+
+	go func() {
+		c := tls.Client(src, &tls.Config{InsecureSkipVerify: true, ServerName: "example.com"})
+		HeaderV1{
+			SrcIP:    net.ParseIP("192.168.0.1"),
+			DestIP:   net.ParseIP("192.168.0.2"),
+			SrcPort:  1234,
+			DestPort: 5678,
+		}.WriteTo(c)
+		io.WriteString(c, "hello")
+	}()
+
+	srv := tls.Server(dst, cfg)
+	if !assert.NoError(t, srv.Handshake()) {
+		return
+	}
+
+	c := WrapConnTimeout(srv, time.Second)
+	assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	assert.Equal(t, "192.168.0.2:5678", c.LocalAddr().String())
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(c, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestWrapConnOptional(t *testing.T) {
+	check := func(name, send, expRemote, expData string) {
+		t.Run(name, func(t *testing.T) {
+			src, dst := net.Pipe()
+			defer dst.Close()
+			go func() {
+				io.WriteString(src, send)
+				src.Close()
+			}()
+
+			c, err := WrapConnOptional(dst)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, expRemote, c.RemoteAddr().String())
+			assert.Equal(t, expRemote != "pipe", c.(*Conn).ProxyHeaderPresent())
+
+			data := make([]byte, len(expData))
+			_, err = io.ReadFull(c, data)
+			assert.NoError(t, err)
+			assert.Equal(t, expData, string(data))
+		})
+	}
+
+	check("none", "hi", "pipe", "hi")
+	check("v1-prefix", "PROX", "pipe", "PROX")
+	check("v2-prefix", "\r\n\r\nGET", "pipe", "\r\n\r\nGET")
+	check("v1", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nhi", "192.168.0.1:1234", "hi")
+
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		io.WriteString(src, "PROXY garbage\r\n")
+		src.Close()
+	}()
+	_, err := WrapConnOptional(dst)
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func TestConn_UnknownV1(t *testing.T) {
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		io.WriteString(src, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+		src.Close()
+	}()
+
+	c := WrapConn(dst)
+	h, err := c.ProxyHeader()
+	assert.NoError(t, err)
+	assert.Nil(t, h.SrcAddr())
+	assert.Nil(t, h.DestAddr())
+	assert.Equal(t, dst.RemoteAddr(), c.RemoteAddr())
+	assert.Equal(t, dst.LocalAddr(), c.LocalAddr())
+}
+
+func TestConn_ProxyHeaderPresent_Invalid(t *testing.T) {
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		io.WriteString(src, "hello\r\n")
+		src.Close()
+	}()
+
+	assert.False(t, WrapConn(dst).ProxyHeaderPresent())
+}
+
+func TestConn_HeaderTimeout_Partial(t *testing.T) {
+	src, dst := net.Pipe()
+	defer src.Close()
+	defer dst.Close()
+	go io.WriteString(src, "PROXY TCP4 ")
+
+	c := WrapConnTimeout(dst, 50*time.Millisecond)
+	_, err := c.ProxyHeader()
+	assert.True(t, errors.Is(err, ErrHeaderTimeout), "expected ErrHeaderTimeout, got %v", err)
+	_, err = c.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, ErrHeaderTimeout), "expected ErrHeaderTimeout, got %v", err)
+
+	ne, ok := err.(net.Error)
+	assert.True(t, ok && ne.Timeout(), "net.Error timeout")
+
+	// the original error (os.ErrDeadlineExceeded since Go 1.15) is preserved
+	var ihe *InvalidHeaderErr
+	if assert.True(t, errors.As(err, &ihe), "InvalidHeaderErr") {
+		assert.Equal(t, "PROXY TCP4 ", string(ihe.Read))
+		ne, ok := ihe.Unwrap().(net.Error)
+		assert.True(t, ok && ne.Timeout(), "wrapped net.Error timeout")
+	}
+}
+
+func TestConn_Deadlines(t *testing.T) {
+	hdr := "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	isTimeout := func(err error) bool {
+		ne, ok := err.(net.Error)
+		return ok && ne.Timeout()
+	}
+
+	t.Run("underlying", func(t *testing.T) {
+		src, dst := net.Pipe()
+		defer src.Close()
+		defer dst.Close()
+		go io.WriteString(src, hdr)
+
+		// set before wrapping, with no header timeout
+		deadline := time.Now().Add(50 * time.Millisecond)
+		dst.SetReadDeadline(deadline)
+		c := WrapConnTimeout(dst, 0)
+		_, err := c.ProxyHeader()
+		assert.NoError(t, err)
+
+		_, err = c.Read(make([]byte, 1))
+		assert.True(t, isTimeout(err), "read should time out")
+		assertTimedOutAt(t, deadline)
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		src, dst := net.Pipe()
+		defer src.Close()
+		defer dst.Close()
+		go io.WriteString(src, hdr)
+
+		c := WrapConnTimeout(dst, 10*time.Millisecond)
+		deadline := time.Now().Add(100 * time.Millisecond)
+		c.SetReadDeadline(deadline)
+		_, err := c.ProxyHeader()
+		assert.NoError(t, err)
+
+		// the header deadline must not remain in effect
+		time.Sleep(20 * time.Millisecond)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			io.WriteString(src, "a")
+		}()
+		_, err = c.Read(make([]byte, 1))
+		assert.NoError(t, err)
+
+		_, err = c.Read(make([]byte, 1))
+		assert.True(t, isTimeout(err), "read should time out")
+		assertTimedOutAt(t, deadline)
+	})
+
+	t.Run("earliest", func(t *testing.T) {
+		src, dst := net.Pipe()
+		defer src.Close()
+		defer dst.Close()
+
+		c := WrapConnTimeout(dst, time.Hour)
+		deadline := time.Now().Add(50 * time.Millisecond)
+		c.SetReadDeadline(deadline)
+
+		_, err := c.ProxyHeader()
+		assert.True(t, isTimeout(err), "header should time out")
+		assertTimedOutAt(t, deadline)
+	})
+}
+
+func TestConn_Peek(t *testing.T) {
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		io.WriteString(src, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nhello world")
+		src.Close()
+	}()
+
+	c := WrapConn(dst)
+	h, r, err := c.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+	// app bytes are available to the reader, without consuming them
+	data, err := r.Peek(5)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// consumed from the reader, not returned by Read
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello ", string(buf))
+
+	_, r2, err := c.Peek()
+	assert.NoError(t, err)
+	assert.True(t, r == r2, "same reader")
+
+	rest, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(rest))
+}
+
+func TestConn_Peek_Invalid(t *testing.T) {
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		io.WriteString(src, "hello\r\n")
+		src.Close()
+	}()
+
+	h, r, err := WrapConn(dst).Peek()
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+	assert.Nil(t, h)
+	assert.Nil(t, r)
+}
+
+func TestConn_LocalAddr_UnixOverTCP(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer nl.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", nl.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.UnixAddr{Net: "unix", Name: "/run/src.sock"},
+			Dest:    &net.UnixAddr{Net: "unix", Name: "/run/dst.sock"},
+		}.WriteTo(c)
+	}()
+
+	raw, err := nl.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer raw.Close()
+
+	c := WrapConn(raw)
+	assert.Equal(t, raw.LocalAddr(), c.LocalAddr())
+	assert.IsType(t, &net.TCPAddr{}, c.LocalAddr())
+	assert.Equal(t, "/run/src.sock", c.RemoteAddr().String())
+
+	h, err := c.ProxyHeader()
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/dst.sock", h.DestAddr().String())
+}
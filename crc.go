@@ -0,0 +1,71 @@
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ErrCRCMismatch is returned (within an InvalidHeaderErr) when a V2 header contains
+// a PP2TypeCRC32C TLV that does not match the checksum of the header.
+var ErrCRCMismatch = errors.New("CRC32C checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// findCRC returns the offset of the PP2TypeCRC32C value within hdr, where tlvStart
+// is the offset of the first TLV. If no CRC TLV is present, -1 is returned.
+func findCRC(hdr []byte, tlvStart int) int {
+	for off := tlvStart; off+3 <= len(hdr); {
+		l := int(binary.BigEndian.Uint16(hdr[off+1:]))
+		if PP2Type(hdr[off]) == PP2TypeCRC32C && l == 4 && off+3+l <= len(hdr) {
+			return off + 3
+		}
+		off += 3 + l
+	}
+	return -1
+}
+
+// checksumV2 returns the CRC32C of hdr, computed with the 4 checksum bytes at crcOffset set to zero.
+func checksumV2(hdr []byte, crcOffset int) uint32 {
+	var saved [4]byte
+	copy(saved[:], hdr[crcOffset:])
+	copy(hdr[crcOffset:crcOffset+4], []byte{0, 0, 0, 0})
+	sum := crc32.Checksum(hdr, castagnoli)
+	copy(hdr[crcOffset:], saved[:])
+	return sum
+}
+
+// errCRCLength is returned for a PP2TypeCRC32C TLV with a value that is not 4 bytes.
+var errCRCLength = errors.New("invalid CRC32C TLV length")
+
+// verifyCRC checks the PP2TypeCRC32C TLV of hdr, if present, returning ErrCRCMismatch if it does not match
+// or errCRCLength if any CRC TLV is not 4 bytes.
+func verifyCRC(hdr []byte, tlvStart int) error {
+	for off := tlvStart; off+3 <= len(hdr); {
+		l := int(binary.BigEndian.Uint16(hdr[off+1:]))
+		if PP2Type(hdr[off]) == PP2TypeCRC32C && l != 4 {
+			return errCRCLength
+		}
+		off += 3 + l
+	}
+
+	off := findCRC(hdr, tlvStart)
+	if off == -1 {
+		return nil
+	}
+	if checksumV2(hdr, off) != binary.BigEndian.Uint32(hdr[off:]) {
+		return ErrCRCMismatch
+	}
+	return nil
+}
+
+// CRC32C returns the checksum stored in the PP2TypeCRC32C TLV, if present.
+//
+// The value is returned as-is; it is verified by Parse, but not by this method.
+func (h HeaderV2) CRC32C() (uint32, bool) {
+	v, ok := FindTLV(h, PP2TypeCRC32C)
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(v), true
+}
@@ -0,0 +1,144 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse_CRC32C(t *testing.T) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeCRC32C, Value: make([]byte, 4)},
+		},
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// checksum is computed with the value zeroed, then stored in the last 4 bytes
+	sum := binary.BigEndian.Uint32(data[len(data)-4:])
+	copy(data[len(data)-4:], []byte{0, 0, 0, 0})
+	assert.Equal(t, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)), sum, "written checksum")
+	binary.BigEndian.PutUint32(data[len(data)-4:], sum)
+
+	_, err = Parse(bufio.NewReader(bytes.NewReader(data)))
+	assert.NoError(t, err, "valid")
+
+	data[17]++ // corrupt source address
+	_, err = Parse(bufio.NewReader(bytes.NewReader(data)))
+	if assert.IsType(t, &InvalidHeaderErr{}, err, "corrupt") {
+		assert.Equal(t, ErrCRCMismatch, err.(*InvalidHeaderErr).error)
+		assert.Equal(t, data, err.(*InvalidHeaderErr).Read)
+	}
+
+	// the checksum is always 4 bytes
+	data, err = HeaderV2{TLVs: []TLV{{Type: PP2TypeNOOP, Value: make([]byte, 3)}}}.MarshalBinary()
+	assert.NoError(t, err)
+	data[16] = byte(PP2TypeCRC32C)
+	_, err = Parse(bufio.NewReader(bytes.NewReader(data)))
+	if assert.IsType(t, &InvalidHeaderErr{}, err, "length") {
+		assert.Equal(t, errCRCLength, err.(*InvalidHeaderErr).error)
+	}
+}
+
+func TestHeaderV2_CRC_Modified(t *testing.T) {
+	data, err := HeaderV2{
+		Command:    CmdProxy,
+		Src:        &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:       &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		ComputeCRC: true,
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+	h, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// a modified copy is written with a new checksum, without setting ComputeCRC
+	c := h.(*HeaderV2).Clone()
+	assert.False(t, c.ComputeCRC)
+	c.SetAuthority("example.com")
+	data, err = c.MarshalBinary()
+	assert.NoError(t, err)
+	p, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	if assert.NoError(t, err) {
+		auth, _ := p.(*HeaderV2).Authority()
+		assert.Equal(t, "example.com", auth)
+	}
+}
+
+func TestHeaderV2_ComputeCRC(t *testing.T) {
+	h := HeaderV2{
+		Command:    CmdProxy,
+		Src:        &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:       &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:       []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+		ComputeCRC: true,
+	}
+
+	var buf bytes.Buffer
+	_, err := h.WriteTo(&buf)
+	assert.NoError(t, err)
+	data := buf.Bytes()
+	assert.Equal(t, []byte{0, 12 + 5 + 7}, data[14:16], "Length")
+	assert.Equal(t, []byte{0x03, 0, 4}, data[33:36], "CRC TLV Type/Length")
+
+	sum := binary.BigEndian.Uint32(data[36:])
+	copy(data[36:], []byte{0, 0, 0, 0})
+	assert.Equal(t, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)), sum, "Checksum")
+	binary.BigEndian.PutUint32(data[36:], sum)
+
+	p, err := Parse(bufio.NewReader(&buf))
+	if assert.NoError(t, err) {
+		assert.Len(t, p.(*HeaderV2).TLVs, 2)
+	}
+
+	// existing CRC TLV is reused rather than duplicated
+	h.TLVs = append(h.TLVs, TLV{Type: PP2TypeCRC32C, Value: make([]byte, 4)})
+	data, err = h.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, 16+12+5+7)
+}
+
+func TestHeaderV2_CRC32C(t *testing.T) {
+	h := HeaderV2{
+		Command:    CmdProxy,
+		Src:        &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:       &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		ComputeCRC: true,
+	}
+	_, ok := h.CRC32C()
+	assert.False(t, ok, "missing")
+
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	p, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	crc, ok := p.(*HeaderV2).CRC32C()
+	assert.True(t, ok)
+	assert.Equal(t, binary.BigEndian.Uint32(data[len(data)-4:]), crc)
+	assert.NotZero(t, crc)
+
+	// not validated
+	h = HeaderV2{TLVs: []TLV{{Type: PP2TypeCRC32C, Value: []byte{0xde, 0xad, 0xbe, 0xef}}}}
+	crc, ok = h.CRC32C()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0xdeadbeef), crc)
+
+	h = HeaderV2{TLVs: []TLV{{Type: PP2TypeCRC32C, Value: []byte{1, 2}}}}
+	_, ok = h.CRC32C()
+	assert.False(t, ok, "invalid length")
+}
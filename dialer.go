@@ -0,0 +1,71 @@
+package proxyprotocol
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Dialer will dial connections, sending a PROXY header before returning them.
+type Dialer struct {
+	// Dialer is used to establish connections.
+	Dialer net.Dialer
+
+	// Header, if set, returns the header to send for a new connection with the given
+	// local and remote addresses. If Header is nil, a V2 header with the local address
+	// as the source and the remote address as the destination is sent. If Header returns
+	// nil, no header is sent for that connection.
+	Header func(local, remote net.Addr) Header
+}
+
+// Dial connects to the address on the named network and sends the PROXY header.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using the provided context and sends the PROXY header.
+//
+// The header is written using the deadline of ctx, if any. If writing the header fails,
+// the connection is closed and the error is returned.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr Header
+	if d.Header == nil {
+		var h HeaderV2
+		h.FromConn(c, true)
+		hdr = h
+	} else {
+		hdr = d.Header(c.LocalAddr(), c.RemoteAddr())
+		if hdr == nil {
+			return c, nil
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	_, err = WriteHeader(c, hdr, deadline)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// WriteHeader will write h to c, using deadline as the write deadline if it is non-zero.
+//
+// The write deadline is cleared afterwards, even if writing fails.
+func WriteHeader(c net.Conn, h Header, deadline time.Time) (int64, error) {
+	if !deadline.IsZero() {
+		err := c.SetWriteDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+		defer c.SetWriteDeadline(time.Time{})
+	}
+
+	return h.WriteTo(c)
+}
@@ -0,0 +1,122 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialer(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer nl.Close()
+	l := NewListener(nl, time.Second)
+
+	check := func(name string, d *Dialer, expSrc, expDst func(c net.Conn) string) {
+		t.Run(name, func(t *testing.T) {
+			connCh := make(chan net.Conn, 1)
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					connCh <- nil
+					return
+				}
+				connCh <- c
+			}()
+
+			c, err := d.Dial("tcp", l.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+
+			s := <-connCh
+			if !assert.NotNil(t, s) {
+				return
+			}
+			defer s.Close()
+			assert.Equal(t, expSrc(c), s.RemoteAddr().String(), "SrcAddr")
+			assert.Equal(t, expDst(c), s.LocalAddr().String(), "DestAddr")
+		})
+	}
+
+	check("default", &Dialer{},
+		func(c net.Conn) string { return c.LocalAddr().String() },
+		func(c net.Conn) string { return c.RemoteAddr().String() },
+	)
+	check("v1", &Dialer{
+		Header: func(local, remote net.Addr) Header {
+			return &HeaderV1{
+				SrcIP:    net.ParseIP("192.168.0.1"),
+				SrcPort:  1234,
+				DestIP:   remote.(*net.TCPAddr).IP,
+				DestPort: remote.(*net.TCPAddr).Port,
+			}
+		},
+	},
+		func(c net.Conn) string { return "192.168.0.1:1234" },
+		func(c net.Conn) string { return c.RemoteAddr().String() },
+	)
+}
+
+func TestDialer_NilHeader(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer nl.Close()
+
+	d := &Dialer{Header: func(local, remote net.Addr) Header { return nil }}
+	c, err := d.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	_, err = c.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	s, err := nl.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(s, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestWriteHeader(t *testing.T) {
+	hdr := &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		SrcPort:  1234,
+		DestIP:   net.ParseIP("192.168.0.2"),
+		DestPort: 5678,
+	}
+
+	src, dst := net.Pipe()
+	defer src.Close()
+	defer dst.Close()
+
+	// nobody reading, past deadline
+	_, err := WriteHeader(src, hdr, time.Now().Add(-time.Second))
+	if assert.Error(t, err) {
+		nErr, ok := err.(net.Error)
+		assert.True(t, ok && nErr.Timeout(), "expected timeout error, got %v", err)
+	}
+
+	// deadline is cleared afterwards
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		Parse(bufio.NewReader(dst))
+	}()
+	n, err := WriteHeader(src, hdr, time.Time{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, len("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"), n)
+}
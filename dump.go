@@ -0,0 +1,100 @@
+package proxyprotocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Dump returns a human-readable, annotated breakdown of the raw header in b for debugging.
+//
+// Malformed or truncated input is annotated with where decoding stopped; Dump never panics.
+func Dump(b []byte) string {
+	var s strings.Builder
+	switch {
+	case bytes.HasPrefix(b, sigV2):
+		dumpV2(&s, b)
+	case bytes.HasPrefix(b, []byte("PROXY ")):
+		dumpV1(&s, b)
+	default:
+		n := len(b)
+		if n > 16 {
+			n = 16
+		}
+		fmt.Fprintf(&s, "error: no PROXY signature: %s\n", hex.EncodeToString(b[:n]))
+	}
+	return s.String()
+}
+
+func dumpV1(s *strings.Builder, b []byte) {
+	fmt.Fprintf(s, "Signature: PROXY (v1)\n")
+	end := bytes.Index(b, []byte("\r\n"))
+	if end == -1 {
+		fmt.Fprintf(s, "error: missing CRLF after %d bytes\n", len(b))
+		end = len(b)
+	}
+	fields := strings.Fields(string(b[6:end]))
+	names := []string{"Protocol", "Source Address", "Destination Address", "Source Port", "Destination Port"}
+	for i, f := range fields {
+		if i >= len(names) {
+			fmt.Fprintf(s, "Extra: %q\n", f)
+			continue
+		}
+		fmt.Fprintf(s, "%s: %s\n", names[i], f)
+	}
+	if len(fields) < len(names) && (len(fields) == 0 || fields[0] != "UNKNOWN") {
+		fmt.Fprintf(s, "error: missing %s\n", names[len(fields)])
+	}
+}
+
+func dumpV2(s *strings.Builder, b []byte) {
+	fmt.Fprintf(s, "Signature: %s (v2)\n", hex.EncodeToString(sigV2))
+	if len(b) < 16 {
+		fmt.Fprintf(s, "error: truncated at offset %d (expected 16 bytes)\n", len(b))
+		return
+	}
+
+	fmt.Fprintf(s, "Version/Command: 0x%02x (version %d, %s)\n", b[12], b[12]>>4, Cmd(b[12]&0xf))
+	fmt.Fprintf(s, "Family/Protocol: 0x%02x (%s, %s)\n", b[13], AddrFamily(b[13]>>4), Proto(b[13]&0xf))
+	l := int(binary.BigEndian.Uint16(b[14:]))
+	fmt.Fprintf(s, "Length: %d\n", l)
+
+	end := 16 + l
+	if len(b) < end {
+		fmt.Fprintf(s, "error: truncated at offset %d (expected %d bytes)\n", len(b), end)
+		end = len(b)
+	}
+	data := b[16:end]
+
+	addrLen, ok := addrLenV2(b[13])
+	if !ok {
+		fmt.Fprintf(s, "error: invalid address family\n")
+		return
+	}
+	if len(data) < addrLen {
+		fmt.Fprintf(s, "error: address data truncated at offset %d (expected %d bytes)\n", 16+len(data), 16+addrLen)
+		return
+	}
+	switch addrLen {
+	case 12, 36:
+		ipLen := (addrLen - 4) / 2
+		fmt.Fprintf(s, "Source Address: %s\n", net.IP(data[:ipLen]))
+		fmt.Fprintf(s, "Destination Address: %s\n", net.IP(data[ipLen:2*ipLen]))
+		fmt.Fprintf(s, "Source Port: %d\n", binary.BigEndian.Uint16(data[2*ipLen:]))
+		fmt.Fprintf(s, "Destination Port: %d\n", binary.BigEndian.Uint16(data[2*ipLen+2:]))
+	case 216:
+		fmt.Fprintf(s, "Source Address: %q\n", strings.TrimRight(string(data[:108]), "\x00"))
+		fmt.Fprintf(s, "Destination Address: %q\n", strings.TrimRight(string(data[108:216]), "\x00"))
+	}
+
+	err := iterTLV(data[addrLen:], func(off int, t PP2Type, v []byte) bool {
+		fmt.Fprintf(s, "TLV %s (0x%02x) at offset %d: length %d, value %s\n", t, byte(t), 16+addrLen+off, len(v), hex.EncodeToString(v))
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(s, "error: TLVs: %v\n", err)
+	}
+}
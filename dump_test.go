@@ -0,0 +1,66 @@
+package proxyprotocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDump(t *testing.T) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, `Signature: 0d0a0d0a000d0a515549540a (v2)
+Version/Command: 0x21 (version 2, PROXY)
+Family/Protocol: 0x11 (INET, STREAM)
+Length: 17
+Source Address: 192.168.0.1
+Destination Address: 192.168.0.2
+Source Port: 80
+Destination Port: 90
+TLV ALPN (0x01) at offset 28: length 2, value 6832
+`, Dump(data))
+
+	assert.Equal(t, `Signature: 0d0a0d0a000d0a515549540a (v2)
+Version/Command: 0x21 (version 2, PROXY)
+Family/Protocol: 0x11 (INET, STREAM)
+Length: 17
+error: truncated at offset 20 (expected 33 bytes)
+error: address data truncated at offset 20 (expected 28 bytes)
+`, Dump(data[:20]))
+
+	assert.Equal(t, `Signature: 0d0a0d0a000d0a515549540a (v2)
+error: truncated at offset 14 (expected 16 bytes)
+`, Dump(data[:14]))
+
+	assert.Equal(t, `Signature: PROXY (v1)
+Protocol: TCP4
+Source Address: 192.168.0.1
+Destination Address: 192.168.0.2
+Source Port: 1234
+Destination Port: 5678
+`, Dump([]byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")))
+
+	assert.Equal(t, `Signature: PROXY (v1)
+error: missing CRLF after 24 bytes
+Protocol: TCP4
+Source Address: 192.168.0.1
+Destination Address: 1
+error: missing Source Port
+`, Dump([]byte("PROXY TCP4 192.168.0.1 1")))
+
+	assert.Equal(t, "error: no PROXY signature: 16030100\n", Dump([]byte{0x16, 0x03, 0x01, 0x00}))
+
+	// must not panic on arbitrary input
+	for i := range data {
+		Dump(data[:i])
+	}
+}
@@ -0,0 +1,63 @@
+package proxyprotocol
+
+import "fmt"
+
+// AddrFamily indicates the address family of a V2 header.
+type AddrFamily byte
+
+const (
+	// AddrFamilyUnspec indicates an unknown or unspecified address family, used with CmdLocal
+	// or when the addresses can not be represented.
+	AddrFamilyUnspec AddrFamily = 0x0
+
+	// AddrFamilyInet indicates IPv4 addresses.
+	AddrFamilyInet AddrFamily = 0x1
+
+	// AddrFamilyInet6 indicates IPv6 addresses.
+	AddrFamilyInet6 AddrFamily = 0x2
+
+	// AddrFamilyUnix indicates UNIX socket addresses.
+	AddrFamilyUnix AddrFamily = 0x3
+)
+
+// Proto indicates the transport protocol of a V2 header.
+type Proto byte
+
+const (
+	// ProtoUnspec indicates an unknown or unspecified protocol.
+	ProtoUnspec Proto = 0x0
+
+	// ProtoStream indicates a stream protocol, such as TCP or a UNIX stream socket.
+	ProtoStream Proto = 0x1
+
+	// ProtoDGram indicates a datagram protocol, such as UDP or a UNIX datagram socket.
+	ProtoDGram Proto = 0x2
+)
+
+// String returns "UNSPEC", "INET", "INET6", or "UNIX" for known address families.
+func (f AddrFamily) String() string {
+	switch f {
+	case AddrFamilyUnspec:
+		return "UNSPEC"
+	case AddrFamilyInet:
+		return "INET"
+	case AddrFamilyInet6:
+		return "INET6"
+	case AddrFamilyUnix:
+		return "UNIX"
+	}
+	return fmt.Sprintf("AddrFamily(0x%x)", byte(f))
+}
+
+// String returns "UNSPEC", "STREAM", or "DGRAM" for known protocols.
+func (p Proto) String() string {
+	switch p {
+	case ProtoUnspec:
+		return "UNSPEC"
+	case ProtoStream:
+		return "STREAM"
+	case ProtoDGram:
+		return "DGRAM"
+	}
+	return fmt.Sprintf("Proto(0x%x)", byte(p))
+}
@@ -0,0 +1,42 @@
+package proxyprotocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderV2_FamilyProto(t *testing.T) {
+	check := func(name string, h HeaderV2, fam AddrFamily, proto Proto) {
+		t.Helper()
+		f, p := h.FamilyProto()
+		assert.Equal(t, fam, f, name+" family")
+		assert.Equal(t, proto, p, name+" proto")
+	}
+
+	ip4 := net.ParseIP("192.168.0.1")
+	ip6 := net.ParseIP("::1")
+	check("tcp4", HeaderV2{Command: CmdProxy, Src: &net.TCPAddr{IP: ip4}, Dest: &net.TCPAddr{IP: ip4}}, AddrFamilyInet, ProtoStream)
+	check("udp6", HeaderV2{Command: CmdProxy, Src: &net.UDPAddr{IP: ip6}, Dest: &net.UDPAddr{IP: ip6}}, AddrFamilyInet6, ProtoDGram)
+	check("unixgram", HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unixgram", Name: "a"},
+		Dest:    &net.UnixAddr{Net: "unixgram", Name: "b"},
+	}, AddrFamilyUnix, ProtoDGram)
+	check("mixed", HeaderV2{Command: CmdProxy, Src: &net.TCPAddr{IP: ip4}, Dest: &net.UDPAddr{IP: ip4}}, AddrFamilyUnspec, ProtoUnspec)
+	check("local", HeaderV2{Src: &net.TCPAddr{IP: ip4}, Dest: &net.TCPAddr{IP: ip4}}, AddrFamilyUnspec, ProtoUnspec)
+}
+
+func TestAddrFamily_Proto_String(t *testing.T) {
+	assert.Equal(t, "UNSPEC", AddrFamilyUnspec.String())
+	assert.Equal(t, "INET", AddrFamilyInet.String())
+	assert.Equal(t, "INET6", AddrFamilyInet6.String())
+	assert.Equal(t, "UNIX", AddrFamilyUnix.String())
+	assert.Equal(t, "AddrFamily(0x7)", AddrFamily(7).String())
+
+	assert.Equal(t, "UNSPEC", ProtoUnspec.String())
+	assert.Equal(t, "STREAM", ProtoStream.String())
+	assert.Equal(t, "DGRAM", ProtoDGram.String())
+	assert.Equal(t, "Proto(0xf)", Proto(0xf).String())
+}
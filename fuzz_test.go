@@ -0,0 +1,67 @@
+//go:build go1.18
+// +build go1.18
+
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("PROXY UNKNOWN\r\n"))
+	f.Add([]byte("PROXY UNKNOWN ffff::1 ffff::2 0 0\r\n"))
+	f.Add([]byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"))
+	f.Add([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 5678\r\n"))
+	f.Add([]byte("PROXY TCP6 ::ffff:192.168.0.1 ::ffff:192.168.0.2 1234 5678\r\n"))
+	for _, h := range []HeaderV2{
+		{},
+		{
+			Command: CmdProxy,
+			Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+			Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+			TLVs: []TLV{
+				{Type: PP2TypeALPN, Value: []byte("h2")},
+				{Type: PP2TypeNOOP, Value: []byte{0, 0, 0}},
+			},
+			ComputeCRC: true,
+		},
+		{
+			Command: CmdProxy,
+			Src:     &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53},
+			Dest:    &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53},
+		},
+		{
+			Command: CmdProxy,
+			Src:     &net.UnixAddr{Net: "unix", Name: "\x00client"},
+			Dest:    &net.UnixAddr{Net: "unix", Name: "/run/server.sock"},
+		},
+	} {
+		data, err := h.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		_, err = h.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("write parsed header %s: %v", h, err)
+		}
+		p, err := Parse(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("re-parse %q: %v", buf.Bytes(), err)
+		}
+		if !Equal(h, p) {
+			t.Fatalf("round trip mismatch:\n%s\n%s", h, p)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package proxyprotocol
 
 import (
+	"bytes"
 	"io"
 	"net"
 )
@@ -13,3 +14,173 @@ type Header interface {
 
 	WriteTo(io.Writer) (int64, error)
 }
+
+// AsV1 returns h as a *HeaderV1 if it is a version 1 header.
+//
+// A HeaderV1 value is returned as a pointer to a copy.
+func AsV1(h Header) (*HeaderV1, bool) {
+	switch h := h.(type) {
+	case *HeaderV1:
+		return h, h != nil
+	case HeaderV1:
+		return &h, true
+	}
+	return nil, false
+}
+
+// AsV2 returns h as a *HeaderV2 if it is a version 2 header, providing access to version-specific fields like TLVs.
+//
+// A HeaderV2 value is returned as a pointer to a copy.
+func AsV2(h Header) (*HeaderV2, bool) {
+	switch h := h.(type) {
+	case *HeaderV2:
+		return h, h != nil
+	case HeaderV2:
+		return &h, true
+	}
+	return nil, false
+}
+
+// Equal reports whether a and b describe the same PROXY header.
+//
+// Headers must be the same version. Addresses are compared by network and string form, so an
+// IPv4-mapped IPv6 address is equal to its plain IPv4 form. V1 headers with an UNKNOWN protocol
+// are always equal to each other, as are their encoded forms. V2 headers must also have the same
+// command, address family and protocol (see HeaderV2.FamilyProto), and TLVs, compared in order by type and value.
+func Equal(a, b Header) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.Version() != b.Version() {
+		return false
+	}
+
+	if v1a, ok := AsV1(a); ok {
+		v1b, ok := AsV1(b)
+		if !ok {
+			return false
+		}
+		fam := v1a.protoFam()
+		if fam != v1b.protoFam() {
+			return false
+		}
+		if fam == "UNKNOWN" {
+			return true
+		}
+	}
+
+	if !addrEqual(a.SrcAddr(), b.SrcAddr()) || !addrEqual(a.DestAddr(), b.DestAddr()) {
+		return false
+	}
+
+	v2a, ok := AsV2(a)
+	if !ok {
+		return true
+	}
+	v2b, ok := AsV2(b)
+	if !ok {
+		return false
+	}
+	if v2a.Command != v2b.Command || len(v2a.TLVs) != len(v2b.TLVs) {
+		return false
+	}
+	famA, protoA := v2a.FamilyProto()
+	famB, protoB := v2b.FamilyProto()
+	if famA != famB || protoA != protoB {
+		return false
+	}
+	if !addrEqual(v2a.RawSrc, v2b.RawSrc) || !addrEqual(v2a.RawDest, v2b.RawDest) {
+		return false
+	}
+	for i, t := range v2a.TLVs {
+		if t.Type != v2b.TLVs[i].Type || !bytes.Equal(t.Value, v2b.TLVs[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// addrEqual compares two addresses by network and string form.
+func addrEqual(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
+
+// SourceInCIDR reports whether the source IP of h is contained in the subnet described by cidr.
+//
+// An error is returned only if cidr can not be parsed. Headers without a TCP or UDP
+// source address (e.g. UNIX or UNKNOWN) are never contained.
+func SourceInCIDR(h Header, cidr string) (bool, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	return SourceInNet(h, n), nil
+}
+
+// SourceInNet reports whether the source IP of h is contained in n.
+//
+// IPv4-mapped IPv6 addresses are matched against IPv4 subnets. Headers without a TCP or UDP
+// source address (e.g. UNIX or UNKNOWN) are never contained.
+func SourceInNet(h Header, n *net.IPNet) bool {
+	if h == nil || n == nil {
+		return false
+	}
+	ip := addrIP(h.SrcAddr())
+	if ip == nil {
+		return false
+	}
+	return n.Contains(ip)
+}
+
+// SourcePort returns the source port of h.
+//
+// False is returned if h does not have a TCP or UDP source address (e.g. UNIX or UNKNOWN).
+func SourcePort(h Header) (int, bool) {
+	if h == nil {
+		return 0, false
+	}
+	return addrPort(h.SrcAddr())
+}
+
+// DestPort returns the destination port of h.
+//
+// False is returned if h does not have a TCP or UDP destination address (e.g. UNIX or UNKNOWN).
+func DestPort(h Header) (int, bool) {
+	if h == nil {
+		return 0, false
+	}
+	return addrPort(h.DestAddr())
+}
+
+// addrIP returns the IP of a TCP or UDP address, or nil for all other types.
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// addrString returns a.String(), or "-" if a is nil.
+func addrString(a net.Addr) string {
+	if a == nil {
+		return "-"
+	}
+	return a.String()
+}
+
+// addrPort returns the port of a TCP or UDP address.
+func addrPort(a net.Addr) (int, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.Port, true
+	case *net.UDPAddr:
+		return a.Port, true
+	}
+	return 0, false
+}
@@ -0,0 +1,168 @@
+package proxyprotocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceInCIDR(t *testing.T) {
+	check := func(name string, h Header, cidr string, exp bool) {
+		t.Helper()
+		ok, err := SourceInCIDR(h, cidr)
+		assert.NoError(t, err, name)
+		assert.Equal(t, exp, ok, name)
+	}
+
+	v1 := &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		DestIP:   net.ParseIP("192.168.0.2"),
+		SrcPort:  1234,
+		DestPort: 5678,
+	}
+	check("v1-in", v1, "192.168.0.0/24", true)
+	check("v1-out", v1, "10.0.0.0/8", false)
+
+	check("v2-udp-in", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5678},
+	}, "2001:db8::/32", true)
+	check("v2-mapped-in", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("::ffff:192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("::ffff:192.168.0.2"), Port: 5678},
+	}, "192.168.0.0/24", true)
+	check("v2-unix", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "foo"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: "bar"},
+	}, "0.0.0.0/0", false)
+	check("v2-local", &HeaderV2{}, "0.0.0.0/0", false)
+
+	_, err := SourceInCIDR(v1, "192.168.0.0")
+	assert.Error(t, err, "invalid CIDR")
+}
+
+func TestAsV1_AsV2(t *testing.T) {
+	v1 := &HeaderV1{SrcPort: 1234}
+	v2 := &HeaderV2{Command: CmdProxy}
+
+	h1, ok := AsV1(v1)
+	assert.True(t, ok)
+	assert.True(t, h1 == v1, "same pointer")
+	_, ok = AsV2(v1)
+	assert.False(t, ok)
+
+	h2, ok := AsV2(v2)
+	assert.True(t, ok)
+	assert.True(t, h2 == v2, "same pointer")
+	_, ok = AsV1(v2)
+	assert.False(t, ok)
+
+	h1, ok = AsV1(HeaderV1{SrcPort: 1234})
+	assert.True(t, ok)
+	assert.Equal(t, 1234, h1.SrcPort)
+	h2, ok = AsV2(HeaderV2{Command: CmdProxy})
+	assert.True(t, ok)
+	assert.Equal(t, CmdProxy, h2.Command)
+
+	_, ok = AsV1(nil)
+	assert.False(t, ok)
+	_, ok = AsV2((*HeaderV2)(nil))
+	assert.False(t, ok)
+}
+
+func TestEqual(t *testing.T) {
+	v4 := net.ParseIP("192.168.0.1").To4()
+	mapped := net.ParseIP("::ffff:192.168.0.1")
+	dst := net.ParseIP("192.168.0.2")
+
+	check := func(name string, a, b Header, exp bool) {
+		t.Helper()
+		assert.Equal(t, exp, Equal(a, b), name)
+		assert.Equal(t, exp, Equal(b, a), name+" (reversed)")
+	}
+
+	check("v1", &HeaderV1{SrcIP: v4, SrcPort: 1, DestIP: dst, DestPort: 2}, HeaderV1{SrcIP: mapped, SrcPort: 1, DestIP: dst, DestPort: 2}, true)
+	check("v1 port", &HeaderV1{SrcIP: v4, SrcPort: 1, DestIP: dst, DestPort: 2}, &HeaderV1{SrcIP: v4, SrcPort: 3, DestIP: dst, DestPort: 2}, false)
+	check("v1 unknown", &HeaderV1{}, &HeaderV1{SrcIP: v4, DestIP: net.ParseIP("::1")}, true)
+	check("nil", nil, &HeaderV1{}, false)
+	check("nil both", nil, nil, true)
+
+	h := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: v4, Port: 1},
+		Dest:    &net.TCPAddr{IP: dst, Port: 2},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}
+	c := *h
+	c.Src = &net.TCPAddr{IP: mapped, Port: 1}
+	c.TLVs = []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}}
+	check("v2", h, c, true)
+	check("v2 vs v1", h, &HeaderV1{SrcIP: v4, SrcPort: 1, DestIP: dst, DestPort: 2}, false)
+
+	c.Src = &net.UDPAddr{IP: v4, Port: 1}
+	check("v2 network", h, &c, false)
+
+	c = *h
+	c.TLVs = []TLV{{Type: PP2TypeALPN, Value: []byte("http/1.1")}}
+	check("v2 tlv", h, &c, false)
+
+	c = *h
+	c.Command = CmdLocal
+	check("v2 command", h, &c, false)
+}
+
+func TestHeader_Implementations(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 2}
+
+	for _, h := range []Header{
+		HeaderV1{SrcIP: src.IP, SrcPort: src.Port, DestIP: dst.IP, DestPort: dst.Port},
+		&HeaderV1{SrcIP: src.IP, SrcPort: src.Port, DestIP: dst.IP, DestPort: dst.Port},
+		HeaderV2{Command: CmdProxy, Src: src, Dest: dst},
+		&HeaderV2{Command: CmdProxy, Src: src, Dest: dst},
+	} {
+		assert.Equal(t, src.String(), h.SrcAddr().String(), "%T", h)
+		assert.Equal(t, dst.String(), h.DestAddr().String(), "%T", h)
+	}
+}
+
+func TestSourcePort_DestPort(t *testing.T) {
+	check := func(name string, h Header, src, dst int, ok bool) {
+		t.Helper()
+		port, portOK := SourcePort(h)
+		assert.Equal(t, ok, portOK, name+" source ok")
+		assert.Equal(t, src, port, name+" source")
+		port, portOK = DestPort(h)
+		assert.Equal(t, ok, portOK, name+" dest ok")
+		assert.Equal(t, dst, port, name+" dest")
+	}
+
+	check("v1", &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		SrcPort:  1234,
+		DestIP:   net.ParseIP("192.168.0.2"),
+		DestPort: 5678,
+	}, 1234, 5678, true)
+	check("v1-unknown", &HeaderV1{}, 0, 0, false)
+	check("v2-tcp", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+	}, 1234, 5678, true)
+	check("v2-udp", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("::2"), Port: 5353},
+	}, 53, 5353, true)
+	check("v2-unix", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "/tmp/a.sock"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: "/tmp/b.sock"},
+	}, 0, 0, false)
+	check("v2-local", &HeaderV2{Command: CmdLocal}, 0, 0, false)
+	check("nil", nil, 0, 0, false)
+}
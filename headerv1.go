@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 )
 
 // HeaderV1 contains information relayed by the PROXY protocol version 1 (human-readable) header.
@@ -15,55 +16,191 @@ type HeaderV1 struct {
 	SrcIP    net.IP
 	DestPort int
 	DestIP   net.IP
+
+	// ForceTCP6 will send IPv4 addresses as IPv4-mapped IPv6 addresses (e.g. ::ffff:192.168.0.1)
+	// with the TCP6 protocol, instead of TCP4.
+	//
+	// Parse sets ForceTCP6 for TCP6 headers with IPv4-mapped addresses so they are written unchanged.
+	ForceTCP6 bool
+}
+
+// maxV1Len is the maximum length of a V1 header line, including the CRLF.
+const maxV1Len = 107
+
+// ErrHeaderTooLong is returned (within an InvalidHeaderErr) when a V1 header line
+// exceeds the maximum length of 107 bytes without a terminating CRLF, or a V2 header
+// exceeds ParseOptions.MaxV2Size. It is also returned when writing a V2 header whose
+// TLVs exceed the maximum length.
+var ErrHeaderTooLong = errors.New("header too long")
+
+// readV1Line reads up to and including the first CRLF from r, or maxV1Len bytes, whichever comes first.
+//
+// Buffered data is scanned directly rather than a byte at a time, but nothing past the CRLF
+// (or the length limit) is consumed from r.
+func readV1Line(r *bufio.Reader) ([]byte, error) {
+	buf := make([]byte, 0, maxV1Len)
+	for len(buf) < maxV1Len {
+		if r.Buffered() == 0 {
+			_, err := r.Peek(1)
+			if err != nil {
+				return nil, &InvalidHeaderErr{Version: 1, Read: buf, error: err}
+			}
+		}
+		n := r.Buffered()
+		if n > maxV1Len-len(buf) {
+			n = maxV1Len - len(buf)
+		}
+		avail, _ := r.Peek(n)
+
+		// find a LF preceded by a CR, which may be the last byte already read
+		end := -1
+		for off := 0; off < len(avail); {
+			i := bytes.IndexByte(avail[off:], '\n')
+			if i == -1 {
+				break
+			}
+			i += off
+			if (i > 0 && avail[i-1] == '\r') || (i == 0 && len(buf) > 0 && buf[len(buf)-1] == '\r') {
+				end = i + 1
+				break
+			}
+			off = i + 1
+		}
+		if end != -1 {
+			buf = append(buf, avail[:end]...)
+			r.Discard(end)
+			return buf, nil
+		}
+		buf = append(buf, avail...)
+		r.Discard(len(avail))
+	}
+	return nil, &InvalidHeaderErr{Version: 1, Read: buf, error: ErrHeaderTooLong}
 }
 
-func parseV1(r *bufio.Reader) (*HeaderV1, error) {
-	buf := make([]byte, 0, 108)
-	last := byte(0)
-	for {
-		b, err := r.ReadByte()
+// parseV1Into reads and parses a V1 header from r, overwriting h.
+func parseV1Into(r *bufio.Reader, opts ParseOptions, h *HeaderV1) error {
+	buf, err := readV1Line(r)
+	if err != nil {
+		return err
+	}
+	if opts.StrictV1 {
+		err = checkStrictV1(buf)
 		if err != nil {
-			return nil, &InvalidHeaderErr{Read: buf, error: err}
+			return &InvalidHeaderErr{Version: 1, Read: buf, error: err}
+		}
+	}
+	return parseV1LineInto(buf, h)
+}
+
+// checkStrictV1 validates the format of a V1 header line (including the CRLF) against the specification.
+//
+// Fields must be separated by exactly one space, ports must be decimal without leading zeros, and
+// addresses must be written in the form matching TCP4 or TCP6. UNKNOWN lines are not checked beyond the
+// protocol, as the receiver must ignore the rest of the line.
+func checkStrictV1(buf []byte) error {
+	line := string(bytes.TrimSuffix(buf, []byte("\r\n")))
+	parts := strings.Split(line, " ")
+	if len(parts) >= 2 && parts[0] == "PROXY" && parts[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(parts) < 2 || parts[0] != "PROXY" {
+		return ErrNoSignature
+	}
+	if len(parts) != 6 {
+		return errors.New("invalid field separators")
+	}
+
+	checkIP := func(s string) bool {
+		if parts[1] == "TCP6" {
+			return strings.Contains(s, ":") && net.ParseIP(s) != nil
+		}
+		octets := strings.Split(s, ".")
+		if len(octets) != 4 {
+			return false
 		}
-		buf = append(buf, b)
-		if last == '\r' && b == '\n' {
-			break
+		for _, o := range octets {
+			if !canonicalDecimal(o, 255) {
+				return false
+			}
 		}
-		if len(buf) == 108 {
-			return nil, &InvalidHeaderErr{Read: buf, error: errors.New("header too long")}
+		return true
+	}
+	if !checkIP(parts[2]) {
+		return errors.New("non-canonical source address")
+	}
+	if !checkIP(parts[3]) {
+		return errors.New("non-canonical destination address")
+	}
+	if !canonicalDecimal(parts[4], 65535) {
+		return errors.New("non-canonical source port")
+	}
+	if !canonicalDecimal(parts[5], 65535) {
+		return errors.New("non-canonical destination port")
+	}
+	return nil
+}
+
+// canonicalDecimal reports whether s is a decimal number no greater than max, without leading zeros.
+func canonicalDecimal(s string, max int) bool {
+	if s == "" || len(s) > 5 || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
 		}
-		last = b
+		n = n*10 + int(c-'0')
+	}
+	return n <= max
+}
+
+// parseV1Line parses a complete V1 header line, including the CRLF.
+func parseV1Line(buf []byte) (*HeaderV1, error) {
+	var h HeaderV1
+	err := parseV1LineInto(buf, &h)
+	if err != nil {
+		return nil, err
 	}
-	if bytes.HasPrefix(buf, []byte("PROXY UNKNOWN")) {
+	return &h, nil
+}
+
+// parseV1LineInto implements parseV1Line, overwriting h.
+func parseV1LineInto(buf []byte, h *HeaderV1) error {
+	if bytes.Equal(buf, []byte("PROXY UNKNOWN\r\n")) || bytes.HasPrefix(buf, []byte("PROXY UNKNOWN ")) {
 		// From the documentation:
 		//
 		// For "UNKNOWN", the rest of the line before the
 		// CRLF may be omitted by the sender, and the receiver must ignore anything
 		// presented before the CRLF is found.
-		return &HeaderV1{}, nil
+		*h = HeaderV1{}
+		return nil
+	}
+	if !bytes.HasPrefix(buf, []byte("PROXY ")) {
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: ErrNoSignature}
 	}
 	var fam string
 	var srcIPStr, dstIPStr string
 	var srcPort, dstPort int
 	n, err := fmt.Sscanf(string(buf), string(sigV1), &fam, &srcIPStr, &dstIPStr, &srcPort, &dstPort)
 	if n == 0 && err != nil {
-		return nil, &InvalidHeaderErr{Read: buf, error: err}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: err}
 	}
 	switch fam {
 	case "TCP4", "TCP6":
 		if err != nil {
 			// couldn't parse IP/port
-			return nil, &InvalidHeaderErr{Read: buf, error: err}
+			return &InvalidHeaderErr{Version: 1, Read: buf, error: err}
 		}
 	default:
-		return nil, &InvalidHeaderErr{Read: buf, error: errors.New("unsupported INET protocol/family value")}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: errors.New("unsupported INET protocol/family value")}
 	}
 
 	if srcPort < 0 || srcPort > 65535 {
-		return nil, &InvalidHeaderErr{Read: buf, error: errors.New("invalid source port")}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: errors.New("invalid source port")}
 	}
 	if dstPort < 0 || dstPort > 65535 {
-		return nil, &InvalidHeaderErr{Read: buf, error: errors.New("invalid destination port")}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: errors.New("invalid destination port")}
 	}
 
 	validAddr := func(ip net.IP) bool {
@@ -82,19 +219,21 @@ func parseV1(r *bufio.Reader) (*HeaderV1, error) {
 
 	srcIP := net.ParseIP(srcIPStr)
 	if !validAddr(srcIP) {
-		return nil, &InvalidHeaderErr{Read: buf, error: errors.New("invalid source address")}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: errors.New("invalid source address")}
 	}
 	dstIP := net.ParseIP(dstIPStr)
 	if !validAddr(dstIP) {
-		return nil, &InvalidHeaderErr{Read: buf, error: errors.New("invalid destination address")}
+		return &InvalidHeaderErr{Version: 1, Read: buf, error: errors.New("invalid destination address")}
 	}
 
-	return &HeaderV1{
-		SrcIP:    srcIP,
-		DestIP:   dstIP,
-		SrcPort:  srcPort,
-		DestPort: dstPort,
-	}, nil
+	*h = HeaderV1{
+		SrcIP:     srcIP,
+		DestIP:    dstIP,
+		SrcPort:   srcPort,
+		DestPort:  dstPort,
+		ForceTCP6: fam == "TCP6" && (srcIP.To4() != nil || dstIP.To4() != nil),
+	}
+	return nil
 }
 
 // FromConn will populate header data from the given net.Conn.
@@ -132,11 +271,30 @@ func (h *HeaderV1) FromConn(c net.Conn, outgoing bool) {
 // Version always returns 1.
 func (HeaderV1) Version() int { return 1 }
 
-// SrcAddr returns the TCP source address.
-func (h HeaderV1) SrcAddr() net.Addr { return &net.TCPAddr{IP: h.SrcIP, Port: h.SrcPort} }
+// SrcAddr returns the TCP source address, or nil if the header would be sent as UNKNOWN.
+func (h HeaderV1) SrcAddr() net.Addr {
+	if h.protoFam() == "UNKNOWN" {
+		return nil
+	}
+	return &net.TCPAddr{IP: h.SrcIP, Port: h.SrcPort}
+}
+
+// DestAddr returns the TCP destination address, or nil if the header would be sent as UNKNOWN.
+func (h HeaderV1) DestAddr() net.Addr {
+	if h.protoFam() == "UNKNOWN" {
+		return nil
+	}
+	return &net.TCPAddr{IP: h.DestIP, Port: h.DestPort}
+}
 
-// DestAddr returns the TCP destination address.
-func (h HeaderV1) DestAddr() net.Addr { return &net.TCPAddr{IP: h.DestIP, Port: h.DestPort} }
+// String returns a short description of the header, e.g. "PROXY v1 TCP4 1.2.3.4:1234 -> 5.6.7.8:5678".
+func (h HeaderV1) String() string {
+	fam := h.protoFam()
+	if fam == "UNKNOWN" {
+		return "PROXY v1 UNKNOWN"
+	}
+	return fmt.Sprintf("PROXY v1 %s %s -> %s", fam, h.SrcAddr(), h.DestAddr())
+}
 
 // protoFam will return the protocol & family value for the current configuration.
 //
@@ -145,32 +303,51 @@ func (h HeaderV1) protoFam() string {
 	if h.DestPort >= 0 && h.DestPort <= 65535 && h.SrcPort >= 0 && h.SrcPort <= 65535 {
 		src4 := h.SrcIP.To4() != nil
 		dst4 := h.DestIP.To4() != nil
-		if src4 && dst4 {
+		if src4 && dst4 && !h.ForceTCP6 {
 			return "TCP4"
-		} else if !src4 && !dst4 && h.SrcIP.To16() != nil && h.DestIP.To16() != nil {
+		} else if (h.ForceTCP6 || !src4 && !dst4) && h.SrcIP.To16() != nil && h.DestIP.To16() != nil {
 			return "TCP6"
 		}
 	}
 	return "UNKNOWN"
 }
 
-// WriteTo will write the V1 header to w. The proto/fam will be set to UNKNOWN
-// if source and dest IPs are of mismatched types, or any port is out of bounds.
-func (h HeaderV1) WriteTo(w io.Writer) (int64, error) {
-	var n int
-	var err error
+// MarshalBinary returns the V1 header exactly as it would be written by WriteTo.
+func (h HeaderV1) MarshalBinary() ([]byte, error) {
 	fam := h.protoFam()
 	if fam == "UNKNOWN" {
-		n, err = io.WriteString(w, "PROXY UNKNOWN\r\n")
-	} else {
-		n, err = fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n",
-			fam,
-			h.SrcIP.String(),
-			h.DestIP.String(),
-			h.SrcPort,
-			h.DestPort,
-		)
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	ipString := func(ip net.IP) string {
+		if fam == "TCP6" && ip.To4() != nil {
+			// net.IP.String uses dotted notation for IPv4-mapped addresses
+			return "::ffff:" + ip.To4().String()
+		}
+		return ip.String()
 	}
 
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		fam,
+		ipString(h.SrcIP),
+		ipString(h.DestIP),
+		h.SrcPort,
+		h.DestPort,
+	)), nil
+}
+
+// WriteTo will write the V1 header to w. The proto/fam will be set to UNKNOWN
+// if source and dest IPs are of mismatched types, or any port is out of bounds.
+//
+// The header is written with a single call to w.Write and the returned count
+// is the number of bytes actually written, even on failure. A partially written
+// header can't be resumed on the same stream; to retry, the full header (see MarshalBinary)
+// must be sent from the beginning on a new connection.
+func (h HeaderV1) WriteTo(w io.Writer) (int64, error) {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
 	return int64(n), err
 }
@@ -1,8 +1,10 @@
 package proxyprotocol
 
 import (
+	"bufio"
 	"bytes"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,3 +45,123 @@ func TestHeaderV1_WriteTo(t *testing.T) {
 		"PROXY TCP6 2001:db8:85a3::8a2e:370:7334 2002:db8:85a3::8a2e:370:7334 1234 5678\r\n",
 	)
 }
+
+func TestHeaderV1_WriteTo_Interrupted(t *testing.T) {
+	h := HeaderV1{
+		SrcPort:  1234,
+		DestPort: 5678,
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		DestIP:   net.ParseIP("192.168.0.2"),
+	}
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n", string(data))
+
+	n, err := h.WriteTo(&failWriter{n: 6})
+	assert.Error(t, err)
+	assert.EqualValues(t, 6, n)
+}
+
+func TestHeaderV1_String(t *testing.T) {
+	h := HeaderV1{SrcIP: net.ParseIP("1.2.3.4"), SrcPort: 1234, DestIP: net.ParseIP("5.6.7.8"), DestPort: 5678}
+	assert.Equal(t, "PROXY v1 TCP4 1.2.3.4:1234 -> 5.6.7.8:5678", h.String())
+
+	h = HeaderV1{SrcIP: net.ParseIP("::1"), SrcPort: 1, DestIP: net.ParseIP("::2"), DestPort: 2}
+	assert.Equal(t, "PROXY v1 TCP6 [::1]:1 -> [::2]:2", h.String())
+
+	assert.Equal(t, "PROXY v1 UNKNOWN", HeaderV1{}.String())
+}
+
+func TestHeaderV1_MappedRoundTrip(t *testing.T) {
+	check := func(line string) {
+		t.Helper()
+		h, err := Parse(bufio.NewReader(strings.NewReader(line)))
+		if !assert.NoError(t, err, line) {
+			return
+		}
+		var buf bytes.Buffer
+		_, err = h.WriteTo(&buf)
+		assert.NoError(t, err, line)
+		assert.Equal(t, line, buf.String())
+	}
+
+	check("PROXY TCP6 ::ffff:192.168.0.1 ::ffff:192.168.0.2 1234 5678\r\n")
+	check("PROXY TCP6 ::ffff:192.168.0.1 2001:db8::1 1234 5678\r\n")
+	check("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+
+	// mapped addresses without ForceTCP6 are sent as TCP4
+	h := HeaderV1{SrcIP: net.ParseIP("::ffff:192.168.0.1"), DestIP: net.ParseIP("::ffff:192.168.0.2"), SrcPort: 1, DestPort: 2}
+	var buf bytes.Buffer
+	h.WriteTo(&buf)
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 192.168.0.2 1 2\r\n", buf.String())
+}
+
+// readV1LineBytewise is the previous byte-at-a-time implementation of readV1Line, kept for comparison.
+func readV1LineBytewise(r *bufio.Reader) ([]byte, error) {
+	buf := make([]byte, 0, maxV1Len)
+	last := byte(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, &InvalidHeaderErr{Version: 1, Read: buf, error: err}
+		}
+		buf = append(buf, b)
+		if last == '\r' && b == '\n' {
+			return buf, nil
+		}
+		if len(buf) == maxV1Len {
+			return nil, &InvalidHeaderErr{Version: 1, Read: buf, error: ErrHeaderTooLong}
+		}
+		last = b
+	}
+}
+
+func TestReadV1Line(t *testing.T) {
+	check := func(name, data string) {
+		t.Helper()
+		for _, size := range []int{16, 4096} {
+			// small buffers split the line (and CRLF) across reads
+			r1 := bufio.NewReaderSize(&emptyReader{r: strings.NewReader(data)}, size)
+			r2 := bufio.NewReaderSize(&emptyReader{r: strings.NewReader(data)}, size)
+
+			exp, expErr := readV1LineBytewise(r1)
+			line, err := readV1Line(r2)
+			assert.Equal(t, string(exp), string(line), name)
+			assert.Equal(t, expErr, err, name)
+
+			expRest, _ := r1.Peek(r1.Buffered())
+			rest, _ := r2.Peek(r2.Buffered())
+			assert.Equal(t, string(expRest), string(rest), name+" remaining")
+		}
+	}
+
+	check("v1", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nhello")
+	check("crlf-split", "PROXY UNKNOWN xxxxxxx\r\nhello")
+	check("lone-lf", "PROXY foo\nbar\r\nhello")
+	check("lone-cr", "PROXY foo\rbar\r\r\nhello")
+	check("max", "PROXY UNKNOWN "+strings.Repeat("x", maxV1Len-16)+"\r\nhello")
+	check("too-long", "PROXY UNKNOWN "+strings.Repeat("x", maxV1Len-15)+"\r\nhello")
+	check("cr-at-limit", "PROXY UNKNOWN "+strings.Repeat("x", maxV1Len-15)+"\n")
+	check("eof", "PROXY TCP4 192.168.0.1")
+	check("empty", "")
+}
+
+func benchmarkParseV1(b *testing.B, read func(*bufio.Reader) ([]byte, error)) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nGET / HTTP/1.1\r\n\r\n")
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(data)
+		r.Reset(br)
+		_, err := read(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadV1Line(b *testing.B)          { benchmarkParseV1(b, readV1Line) }
+func BenchmarkReadV1Line_Bytewise(b *testing.B) { benchmarkParseV1(b, readV1LineBytewise) }
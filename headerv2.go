@@ -5,16 +5,51 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
 )
 
 // HeaderV2 contains information relayed by the PROXY protocol version 2 (binary) header.
+//
+// Ports are not range-checked beyond fitting in 16 bits, so port 0 (which may be
+// legitimate for UDP flows) is written and parsed as-is.
+//
+// UNIX address names are sent as-is, so abstract socket names should begin with a NUL byte
+// (not '@'). Trailing NUL padding is removed when parsing, while a leading NUL is preserved.
 type HeaderV2 struct {
 	Command Cmd
 	Src     net.Addr
 	Dest    net.Addr
+
+	// TLVs contains any additional Type-Length-Value fields following the address data.
+	TLVs []TLV
+
+	// Trailing contains a copy of the raw bytes following the address data of a parsed header,
+	// from which TLVs was decoded, or nil if there were none. It is not updated when TLVs is
+	// modified, and is ignored when writing the header; TLVs is written instead.
+	Trailing []byte
+
+	// RawSrc and RawDest contain the addresses sent with a LOCAL command. They are only
+	// populated when parsing with IncludeLocalAddrs set, and are otherwise ignored, including when
+	// writing the header. Receivers should use the real connection endpoints for LOCAL connections.
+	RawSrc, RawDest net.Addr
+
+	// ForceInet6 will send IPv4 addresses as IPv4-mapped IPv6 addresses (e.g. ::ffff:192.168.0.1)
+	// with the INET6 family, instead of INET. This also allows an IPv4 address to be sent with an IPv6
+	// address, which is otherwise rejected by Validate.
+	//
+	// Parse sets ForceInet6 for INET6 headers with IPv4-mapped addresses so they are written unchanged.
+	ForceInet6 bool
+
+	// ComputeCRC, if set, will cause a PP2TypeCRC32C TLV to be appended when the header is
+	// written, if one is not already present in TLVs.
+	//
+	// The value of a PP2TypeCRC32C TLV is always replaced with the checksum of the header being
+	// written, so a parsed header can be modified (e.g. with SetAuthority) and written again.
+	ComputeCRC bool
 }
 
 type rawV2 struct {
@@ -24,128 +59,255 @@ type rawV2 struct {
 	Len      uint16
 }
 
-func parseV2(r *bufio.Reader) (*HeaderV2, error) {
-	buf := make([]byte, 232)
-	n, err := io.ReadFull(r, buf[:16])
+// v2BufPool holds buffers large enough for a V2 header with the largest address block.
+//
+// Nothing returned from parseV2 may reference a pooled buffer.
+var v2BufPool = sync.Pool{New: func() interface{} {
+	b := make([]byte, 232)
+	return &b
+}}
+
+// invalidHeaderCopy returns an InvalidHeaderErr with a copy of read, so it does not reference a pooled buffer.
+func invalidHeaderCopy(read []byte, err error) *InvalidHeaderErr {
+	return &InvalidHeaderErr{Version: 2, Read: append([]byte(nil), read...), error: err}
+}
+
+func parseV2(r *bufio.Reader, opts ParseOptions) (*HeaderV2, error) {
+	var h HeaderV2
+	_, err := parseV2Into(r, opts, false, &h)
 	if err != nil {
-		return nil, &InvalidHeaderErr{Read: buf[:n], error: err}
+		return nil, err
 	}
-	var rawHdr rawV2
-	err = binary.Read(bytes.NewReader(buf), binary.BigEndian, &rawHdr)
+	return &h, nil
+}
+
+// parseV2Raw is like parseV2, also returning a copy of the complete header as read.
+func parseV2Raw(r *bufio.Reader, opts ParseOptions) (*HeaderV2, []byte, error) {
+	var h HeaderV2
+	raw, err := parseV2Into(r, opts, true, &h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &h, raw, nil
+}
+
+// parseV2Into implements parseV2, overwriting dst and returning a copy of the complete header as read if
+// keepRaw is set.
+func parseV2Into(r *bufio.Reader, opts ParseOptions, keepRaw bool, dst *HeaderV2) ([]byte, error) {
+	bp := v2BufPool.Get().(*[]byte)
+	defer v2BufPool.Put(bp)
+	buf := *bp
+
+	n, err := io.ReadFull(r, buf[:16])
 	if err != nil {
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: err}
+		return nil, invalidHeaderCopy(buf[:n], err)
 	}
+	var rawHdr rawV2
+	copy(rawHdr.Sig[:], buf)
+	rawHdr.VerCmd = buf[12]
+	rawHdr.FamProto = buf[13]
+	rawHdr.Len = binary.BigEndian.Uint16(buf[14:])
 	if !bytes.Equal(rawHdr.Sig[:], sigV2) {
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid signature")}
+		return nil, invalidHeaderCopy(buf[:16], ErrNoSignature)
 	}
 	// highest 4 indicate version
 	if (rawHdr.VerCmd >> 4) != 2 {
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid v2 version value")}
+		return nil, invalidHeaderCopy(buf[:16], errors.New("invalid v2 version value"))
 	}
 	var h HeaderV2
 	// lowest 4 = command (0xf == 0b00001111)
 	h.Command = Cmd(rawHdr.VerCmd & 0xf)
 	if h.Command > CmdProxy {
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid v2 command")}
+		return nil, invalidHeaderCopy(buf[:16], errors.New("invalid v2 command"))
 	}
 
 	// highest 4 indicate address family
-	switch rawHdr.FamProto >> 4 {
-	case 0: // local
-		if rawHdr.Len != 0 {
-			return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid length")}
-		}
-	case 1: // ipv4
-		if rawHdr.Len != 12 {
-			return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid length")}
-		}
-	case 2: // ipv6
-		if rawHdr.Len != 36 {
-			return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid length")}
-		}
-	case 3: // unix
-		if rawHdr.Len != 216 {
-			return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid length")}
-		}
-	default:
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid v2 address family")}
+	addrLen, ok := addrLenV2(rawHdr.FamProto)
+	if !ok {
+		return nil, invalidHeaderCopy(buf[:16], errors.New("invalid v2 address family"))
+	}
+	if int(rawHdr.Len) < addrLen {
+		return nil, invalidHeaderCopy(buf[:16], errors.New("invalid length"))
 	}
 
 	// lowest 4 = transport protocol (0xf == 0b00001111)
 	if (rawHdr.FamProto & 0xf) > 2 {
-		return nil, &InvalidHeaderErr{Read: buf[:16], error: errors.New("invalid v2 transport protocol")}
+		return nil, invalidHeaderCopy(buf[:16], errors.New("invalid v2 transport protocol"))
+	}
+	if opts.MaxV2Size > 0 && 16+int(rawHdr.Len) > opts.MaxV2Size {
+		return nil, invalidHeaderCopy(buf[:16], ErrHeaderTooLong)
 	}
 
-	buf = buf[:16+int(rawHdr.Len)]
+	if 16+int(rawHdr.Len) > len(buf) {
+		newBuf := make([]byte, 16+int(rawHdr.Len))
+		copy(newBuf, buf[:16])
+		buf = newBuf
+	} else {
+		buf = buf[:16+int(rawHdr.Len)]
+	}
 
 	n, err = io.ReadFull(r, buf[16:])
 	if err != nil {
-		return nil, &InvalidHeaderErr{Read: buf[:16+n], error: err}
+		return nil, invalidHeaderCopy(buf[:16+n], err)
+	}
+
+	h.TLVs, err = ParseTLVs(buf[16+addrLen:])
+	if err != nil {
+		return nil, invalidHeaderCopy(buf, err)
+	}
+	if len(buf) > 16+addrLen {
+		h.Trailing = append([]byte(nil), buf[16+addrLen:]...)
+	}
+	err = verifyCRC(buf, 16+addrLen)
+	if err != nil {
+		return nil, invalidHeaderCopy(buf, err)
 	}
 
 	if h.Command == CmdLocal {
 		// ignore address information for local
-		return &h, nil
+		if opts.IncludeLocalAddrs {
+			h.RawSrc, h.RawDest = parseAddrV2(rawHdr.FamProto, buf)
+		}
+	} else {
+		h.Src, h.Dest = parseAddrV2(rawHdr.FamProto, buf)
+		h.ForceInet6 = AddrFamily(rawHdr.FamProto>>4) == AddrFamilyInet6 && (addrIP(h.Src).To4() != nil || addrIP(h.Dest).To4() != nil)
 	}
 
-	switch rawHdr.FamProto {
+	*dst = h
+	if keepRaw {
+		return append([]byte(nil), buf...), nil
+	}
+	return nil, nil
+}
+
+// parseAddrV2 will decode the source and destination addresses for famProto from the full header in buf.
+//
+// Nil addresses are returned for unspecified families or protocols. The returned addresses
+// do not reference buf.
+func parseAddrV2(famProto byte, buf []byte) (src, dst net.Addr) {
+	switch famProto {
 	case 0x11: // TCP over IPv4
-		h.Src = &net.TCPAddr{
-			IP:   net.IP(buf[16:20]),
+		src = &net.TCPAddr{
+			IP:   copyIP(buf[16:20]),
 			Port: int(binary.BigEndian.Uint16(buf[24:])),
 		}
-		h.Dest = &net.TCPAddr{
-			IP:   net.IP(buf[20:24]),
+		dst = &net.TCPAddr{
+			IP:   copyIP(buf[20:24]),
 			Port: int(binary.BigEndian.Uint16(buf[26:])),
 		}
 	case 0x12: // UDP over IPv4
-		h.Src = &net.UDPAddr{
-			IP:   net.IP(buf[16:20]),
+		src = &net.UDPAddr{
+			IP:   copyIP(buf[16:20]),
 			Port: int(binary.BigEndian.Uint16(buf[24:])),
 		}
-		h.Dest = &net.UDPAddr{
-			IP:   net.IP(buf[20:24]),
+		dst = &net.UDPAddr{
+			IP:   copyIP(buf[20:24]),
 			Port: int(binary.BigEndian.Uint16(buf[26:])),
 		}
 	case 0x21: // TCP over IPv6
-		h.Src = &net.TCPAddr{
-			IP:   net.IP(buf[16:32]),
+		src = &net.TCPAddr{
+			IP:   copyIP(buf[16:32]),
 			Port: int(binary.BigEndian.Uint16(buf[48:])),
 		}
-		h.Dest = &net.TCPAddr{
-			IP:   net.IP(buf[32:48]),
+		dst = &net.TCPAddr{
+			IP:   copyIP(buf[32:48]),
 			Port: int(binary.BigEndian.Uint16(buf[50:])),
 		}
 	case 0x22: // UDP over IPv6
-		h.Src = &net.UDPAddr{
-			IP:   net.IP(buf[16:32]),
+		src = &net.UDPAddr{
+			IP:   copyIP(buf[16:32]),
 			Port: int(binary.BigEndian.Uint16(buf[48:])),
 		}
-		h.Dest = &net.UDPAddr{
-			IP:   net.IP(buf[32:48]),
+		dst = &net.UDPAddr{
+			IP:   copyIP(buf[32:48]),
 			Port: int(binary.BigEndian.Uint16(buf[50:])),
 		}
 	case 0x31: // UNIX stream
-		h.Src = &net.UnixAddr{
+		src = &net.UnixAddr{
 			Net:  "unix",
 			Name: strings.TrimRight(string(buf[16:124]), "\x00"),
 		}
-		h.Dest = &net.UnixAddr{
+		dst = &net.UnixAddr{
 			Net:  "unix",
 			Name: strings.TrimRight(string(buf[124:232]), "\x00"),
 		}
 	case 0x32: // UNIX datagram
-		h.Src = &net.UnixAddr{
+		src = &net.UnixAddr{
 			Net:  "unixgram",
 			Name: strings.TrimRight(string(buf[16:124]), "\x00"),
 		}
-		h.Dest = &net.UnixAddr{
+		dst = &net.UnixAddr{
 			Net:  "unixgram",
 			Name: strings.TrimRight(string(buf[124:232]), "\x00"),
 		}
 	}
 
-	return &h, nil
+	return src, dst
+}
+
+// copyIP returns a copy of b as a net.IP.
+func copyIP(b []byte) net.IP { return append(net.IP(nil), b...) }
+
+// addrLenV2 returns the length of the address data for the address family of famProto.
+func addrLenV2(famProto byte) (int, bool) {
+	// highest 4 indicate address family
+	switch AddrFamily(famProto >> 4) {
+	case AddrFamilyUnspec:
+		return 0, true
+	case AddrFamilyInet:
+		return 12, true
+	case AddrFamilyInet6:
+		return 36, true
+	case AddrFamilyUnix:
+		return 216, true
+	}
+	return 0, false
+}
+
+// Clone returns a deep copy of h, including the TLVs (and their values), Trailing bytes, and addresses.
+//
+// The returned header is fully independent of h, so either may be modified (e.g. to change a TLV
+// before forwarding a header) without affecting the other. Address types other than
+// *net.TCPAddr, *net.UDPAddr and *net.UnixAddr are not copied.
+func (h HeaderV2) Clone() *HeaderV2 {
+	c := h
+	c.Src = cloneAddr(h.Src)
+	c.Dest = cloneAddr(h.Dest)
+	c.RawSrc = cloneAddr(h.RawSrc)
+	c.RawDest = cloneAddr(h.RawDest)
+	if h.TLVs != nil {
+		c.TLVs = make([]TLV, len(h.TLVs))
+		for i, t := range h.TLVs {
+			c.TLVs[i] = TLV{Type: t.Type, Value: append([]byte(nil), t.Value...)}
+		}
+	}
+	if h.Trailing != nil {
+		c.Trailing = append([]byte(nil), h.Trailing...)
+	}
+	return &c
+}
+
+// cloneAddr returns a copy of a TCP, UDP or UNIX address, or a as-is for other types.
+func cloneAddr(a net.Addr) net.Addr {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if a == nil {
+			return a
+		}
+		return &net.TCPAddr{IP: copyIP(a.IP), Port: a.Port, Zone: a.Zone}
+	case *net.UDPAddr:
+		if a == nil {
+			return a
+		}
+		return &net.UDPAddr{IP: copyIP(a.IP), Port: a.Port, Zone: a.Zone}
+	case *net.UnixAddr:
+		if a == nil {
+			return a
+		}
+		u := *a
+		return &u
+	}
+	return a
 }
 
 // FromConn will populate header data from the given net.Conn.
@@ -154,6 +316,10 @@ func parseV2(r *bufio.Reader) (*HeaderV2, error) {
 // and the LocalAddr of the Conn will be considered the Destination address/port for
 // the purposes of the PROXY header if outgoing is false, if outgoing is true, the
 // inverse is true.
+//
+// If the addresses can not be sent (see Validate), for example if either is not a TCP, UDP,
+// or UNIX address, both are set to nil. The header is then sent with the UNSPEC address family,
+// indicating the receiver should use the real connection endpoints.
 func (h *HeaderV2) FromConn(c net.Conn, outgoing bool) {
 	h.Command = CmdProxy
 	if outgoing {
@@ -163,6 +329,62 @@ func (h *HeaderV2) FromConn(c net.Conn, outgoing bool) {
 		h.Src = c.RemoteAddr()
 		h.Dest = c.LocalAddr()
 	}
+	if (HeaderV2{Command: CmdProxy, Src: h.Src, Dest: h.Dest}).Validate() != nil {
+		h.Src, h.Dest = nil, nil
+	}
+}
+
+// SetSource sets the source address to a, converting the destination address to the same transport
+// protocol if needed, so that the header does not fail Validate due to mismatched address types.
+//
+// For example, if Dest is a *net.TCPAddr and a is a *net.UDPAddr, Dest is replaced with a *net.UDPAddr
+// with the same IP and port, and FamilyProto will report ProtoDGram. Likewise, a UNIX destination address
+// is given the same Net as a. Only the type of the other address changes, never its IP, port, or name.
+//
+// If both addresses are IP addresses, ForceInet6 is set if one is IPv4 (including IPv4-mapped IPv6) and
+// the other is IPv6, so both are sent as IPv6 (AddrFamilyInet6) with the IPv4 address sent as IPv4-mapped,
+// and cleared otherwise. To send two IPv4 addresses as IPv6, set ForceInet6 after the addresses.
+func (h *HeaderV2) SetSource(a net.Addr) {
+	h.Src = a
+	h.Dest = matchAddrProto(h.Dest, a)
+	h.matchAddrFamily()
+}
+
+// SetDest sets the destination address to a, converting the source address to the same transport
+// protocol and setting ForceInet6 if needed, as described by SetSource.
+func (h *HeaderV2) SetDest(a net.Addr) {
+	h.Dest = a
+	h.Src = matchAddrProto(h.Src, a)
+	h.matchAddrFamily()
+}
+
+// matchAddrFamily sets ForceInet6 if one IP address is IPv4 and the other IPv6, or clears it if both are
+// the same family. It is left unchanged if either address is not an IP address.
+func (h *HeaderV2) matchAddrFamily() {
+	src, dst := addrIP(h.Src), addrIP(h.Dest)
+	if len(src) > 0 && len(dst) > 0 {
+		h.ForceInet6 = (src.To4() == nil) != (dst.To4() == nil)
+	}
+}
+
+// matchAddrProto returns a converted to the same address type and network as ref, or a unchanged if it
+// already matches or can't be converted. The address a is never modified.
+func matchAddrProto(a, ref net.Addr) net.Addr {
+	switch ref := ref.(type) {
+	case *net.TCPAddr:
+		if u, ok := a.(*net.UDPAddr); ok && u != nil {
+			return &net.TCPAddr{IP: u.IP, Port: u.Port, Zone: u.Zone}
+		}
+	case *net.UDPAddr:
+		if t, ok := a.(*net.TCPAddr); ok && t != nil {
+			return &net.UDPAddr{IP: t.IP, Port: t.Port, Zone: t.Zone}
+		}
+	case *net.UnixAddr:
+		if u, ok := a.(*net.UnixAddr); ok && u != nil && ref != nil && u.Net != ref.Net {
+			return &net.UnixAddr{Name: u.Name, Net: ref.Net}
+		}
+	}
+	return a
 }
 
 // Version always returns 2.
@@ -174,35 +396,197 @@ func (h HeaderV2) SrcAddr() net.Addr { return h.Src }
 // DestAddr returns the destination address as TCP, UDP, Unix, or nil depending on Protocol and Family.
 func (h HeaderV2) DestAddr() net.Addr { return h.Dest }
 
-// WriteTo will write the V2 header to w. Command must be CommandProxy
-// to send any address data.
-func (h HeaderV2) WriteTo(w io.Writer) (int64, error) {
-	if h.Command > CmdProxy {
-		return 0, errors.New("invalid command")
+// FamilyProto returns the address family and protocol that will be sent for the current
+// Command and addresses. Both are unspecified for CmdLocal or if the addresses can't be sent.
+func (h HeaderV2) FamilyProto() (AddrFamily, Proto) {
+	if h.Command != CmdProxy {
+		return AddrFamilyUnspec, ProtoUnspec
+	}
+	famProto := writeAddrV2(newBuffer(16, 232), h.Src, h.Dest, h.ForceInet6)
+	return AddrFamily(famProto >> 4), Proto(famProto & 0xf)
+}
+
+// String returns a short description of the header, including the types of any TLVs,
+// e.g. "PROXY v2 PROXY TCP4 1.2.3.4:1234 -> 5.6.7.8:5678 [ALPN Authority]".
+//
+// Nil addresses are shown as "-".
+func (h HeaderV2) String() string {
+	types := make([]PP2Type, len(h.TLVs))
+	for i, t := range h.TLVs {
+		types[i] = t.Type
+	}
+	return fmt.Sprintf("PROXY v2 %s %s %s -> %s %v", h.Command, famProtoName(h.FamilyProto()), addrString(h.Src), addrString(h.Dest), types)
+}
+
+// famProtoName returns a short name for the family and protocol sent in a header.
+func famProtoName(fam AddrFamily, proto Proto) string {
+	switch {
+	case fam == AddrFamilyInet && proto == ProtoStream:
+		return "TCP4"
+	case fam == AddrFamilyInet6 && proto == ProtoStream:
+		return "TCP6"
+	case fam == AddrFamilyInet && proto == ProtoDGram:
+		return "UDP4"
+	case fam == AddrFamilyInet6 && proto == ProtoDGram:
+		return "UDP6"
+	case fam == AddrFamilyUnix && proto == ProtoStream:
+		return "UNIX_STREAM"
+	case fam == AddrFamilyUnix && proto == ProtoDGram:
+		return "UNIX_DGRAM"
+	}
+	return "UNSPEC"
+}
+
+// MarshalBinary returns the V2 header exactly as it would be written by WriteTo.
+//
+// Command must be CmdProxy to include any address data. The header is checked with
+// Validate first, and any error is returned. ErrHeaderTooLong is returned if the addresses
+// and TLVs do not fit within the maximum length of a V2 header.
+func (h HeaderV2) MarshalBinary() ([]byte, error) {
+	err := h.Validate()
+	if err != nil {
+		return nil, err
 	}
 
 	var rawHdr rawV2
 	copy(rawHdr.Sig[:], sigV2)
 	rawHdr.VerCmd = (2 << 4) | (0xf & byte(h.Command))
-	sendEmpty := func() (int64, error) {
-		err := binary.Write(w, binary.BigEndian, rawHdr)
-		if err != nil {
-			return 0, err
+
+	// room for the largest address block, all TLVs, and a possible CRC32C TLV
+	buf := newBuffer(16, 232+TLVLen(h.TLVs)+7)
+	if h.Command == CmdProxy {
+		rawHdr.FamProto = writeAddrV2(buf, h.Src, h.Dest, h.ForceInet6)
+	}
+
+	tlvStart := buf.Len()
+	hasCRC := false
+	for _, t := range h.TLVs {
+		if len(t.Value) > 0xffff {
+			return nil, errors.New("TLV value too long")
 		}
-		return 16, nil
+		if t.Type == PP2TypeCRC32C {
+			if len(t.Value) != 4 {
+				return nil, errCRCLength
+			}
+			hasCRC = true
+		}
+		buf.Write([]byte{byte(t.Type)})
+		binary.Write(buf, binary.BigEndian, uint16(len(t.Value)))
+		buf.Write(t.Value)
 	}
-	if h.Command == CmdLocal {
-		return sendEmpty()
+	if h.ComputeCRC && !hasCRC {
+		buf.Write([]byte{byte(PP2TypeCRC32C), 0, 4, 0, 0, 0, 0})
+	}
+
+	if buf.Len()-16 > 0xffff {
+		return nil, ErrHeaderTooLong
+	}
+	rawHdr.Len = uint16(buf.Len() - 16)
+
+	buf.Seek(0)
+	err = binary.Write(buf, binary.BigEndian, rawHdr)
+	if err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if h.ComputeCRC || hasCRC {
+		off := findCRC(data, tlvStart)
+		binary.BigEndian.PutUint32(data[off:], checksumV2(data, off))
 	}
 
-	buf := newBuffer(16, 232)
+	return data, nil
+}
+
+// Errors returned (wrapped) by Validate, and when writing a V2 header.
+var (
+	// ErrUnixPathTooLong indicates a UNIX address name is longer than the 108 bytes available.
+	ErrUnixPathTooLong = errors.New("unix address name too long (max 108 bytes)")
+
+	// ErrAddrTypeMismatch indicates the source and destination are different types of address.
+	ErrAddrTypeMismatch = errors.New("address type mismatch")
 
+	// ErrAddrFamilyMismatch indicates the source and destination IPs are of different families.
+	ErrAddrFamilyMismatch = errors.New("address family mismatch")
+)
+
+// Validate checks that the header can be sent as-is, returning a descriptive error otherwise.
+// Errors for mismatched or oversized addresses wrap ErrAddrTypeMismatch, ErrAddrFamilyMismatch,
+// or ErrUnixPathTooLong.
+//
+// With CmdProxy, Src and Dest must either both be nil (sent as UNSPEC) or be TCP, UDP, or UNIX
+// addresses of the same type and family (unless ForceInet6 is set), with valid ports and names.
+func (h HeaderV2) Validate() error {
+	if h.Command > CmdProxy {
+		return errors.New("invalid command")
+	}
+	if h.Command != CmdProxy || (h.Src == nil && h.Dest == nil) {
+		return nil
+	}
+	if h.Src == nil {
+		return errors.New("source address is nil")
+	}
+	if h.Dest == nil {
+		return errors.New("destination address is nil")
+	}
+	if fmt.Sprintf("%T", h.Src) != fmt.Sprintf("%T", h.Dest) {
+		return fmt.Errorf("%w: destination address is %T, expected %T to match source", ErrAddrTypeMismatch, h.Dest, h.Src)
+	}
+
+	switch src := h.Src.(type) {
+	case *net.TCPAddr, *net.UDPAddr:
+		srcIP, dstIP := addrIP(src), addrIP(h.Dest)
+		if srcIP.To16() == nil {
+			return errors.New("invalid source IP")
+		}
+		if dstIP.To16() == nil {
+			return errors.New("invalid destination IP")
+		}
+		if (srcIP.To4() == nil) != (dstIP.To4() == nil) && !h.ForceInet6 {
+			return fmt.Errorf("%w: source and destination IPs must both be IPv4 or IPv6", ErrAddrFamilyMismatch)
+		}
+		srcPort, _ := addrPort(src)
+		if srcPort < 0 || srcPort > 0xffff {
+			return errors.New("invalid source port")
+		}
+		dstPort, _ := addrPort(h.Dest)
+		if dstPort < 0 || dstPort > 0xffff {
+			return errors.New("invalid destination port")
+		}
+	case *net.UnixAddr:
+		dst := h.Dest.(*net.UnixAddr)
+		if src.Net != "unix" && src.Net != "unixgram" {
+			return fmt.Errorf("unsupported unix network %q", src.Net)
+		}
+		if src.Net != dst.Net {
+			return fmt.Errorf("%w: destination network is %q, expected %q to match source", ErrAddrTypeMismatch, dst.Net, src.Net)
+		}
+		if len(src.Name) > 108 {
+			return fmt.Errorf("source %w", ErrUnixPathTooLong)
+		}
+		if len(dst.Name) > 108 {
+			return fmt.Errorf("destination %w", ErrUnixPathTooLong)
+		}
+	default:
+		return fmt.Errorf("unsupported address type %T", h.Src)
+	}
+
+	return nil
+}
+
+// writeAddrV2 will write the address data for src and dst to buf, returning
+// the family & protocol value. If the addresses can not be represented, nothing
+// is written and 0 (UNSPEC) is returned.
+//
+// IP addresses are written as INET if both are IPv4, or INET6 if both are IPv6. If forceInet6 is set,
+// INET6 is always used, with any IPv4 address written as an IPv4-mapped IPv6 address.
+func writeAddrV2(buf *buffer, src, dst net.Addr, forceInet6 bool) byte {
 	setAddr := func(srcIP, dstIP net.IP, srcPort, dstPort int) (fam byte) {
 		src := srcIP.To4()
 		dst := dstIP.To4()
-		if src != nil && dst != nil {
+		if src != nil && dst != nil && !forceInet6 {
 			fam = 0x1 // INET
-		} else if src == nil && dst == nil {
+		} else if (src == nil && dst == nil) || forceInet6 {
 			src = srcIP.To16()
 			dst = dstIP.To16()
 			fam = 0x2 // INET6
@@ -219,57 +603,141 @@ func (h HeaderV2) WriteTo(w io.Writer) (int64, error) {
 		return fam
 	}
 
-	switch src := h.Src.(type) {
+	switch src := src.(type) {
 	case *net.TCPAddr:
-		dst, ok := h.Dest.(*net.TCPAddr)
+		dst, ok := dst.(*net.TCPAddr)
 		if !ok {
-			return sendEmpty()
+			return 0
 		}
 		addrFam := setAddr(src.IP, dst.IP, src.Port, dst.Port)
 		if addrFam == 0 {
-			return sendEmpty()
+			return 0
 		}
-		rawHdr.FamProto = (addrFam << 4) | 0x1 // 0x1 == STREAM
+		return (addrFam << 4) | 0x1 // 0x1 == STREAM
 	case *net.UDPAddr:
-		dst, ok := h.Dest.(*net.UDPAddr)
+		dst, ok := dst.(*net.UDPAddr)
 		if !ok {
-			return sendEmpty()
+			return 0
 		}
 		addrFam := setAddr(src.IP, dst.IP, src.Port, dst.Port)
 		if addrFam == 0 {
-			return sendEmpty()
+			return 0
 		}
-		rawHdr.FamProto = (addrFam << 4) | 0x2 // 0x2 == DGRAM
+		return (addrFam << 4) | 0x2 // 0x2 == DGRAM
 	case *net.UnixAddr:
-		dst, ok := h.Dest.(*net.UnixAddr)
+		dst, ok := dst.(*net.UnixAddr)
 		if !ok || src.Net != dst.Net {
-			return sendEmpty()
+			return 0
 		}
 		if len(src.Name) > 108 || len(dst.Name) > 108 {
 			// name too long to use
-			return sendEmpty()
+			return 0
 		}
+		var famProto byte
 		switch src.Net {
 		case "unix":
-			rawHdr.FamProto = (0x3 << 4) | 0x1 // 0x3 (UNIX) | 0x1 (STREAM)
+			famProto = (0x3 << 4) | 0x1 // 0x3 (UNIX) | 0x1 (STREAM)
 		case "unixgram":
-			rawHdr.FamProto = (0x3 << 4) | 0x2 // 0x3 (UNIX) | 0x2 (DGRAM)
+			famProto = (0x3 << 4) | 0x2 // 0x3 (UNIX) | 0x2 (DGRAM)
 		default:
-			return sendEmpty()
+			return 0
 		}
 		buf.Write([]byte(src.Name))
 		buf.Seek(108 + 16)
 		buf.Write([]byte(dst.Name))
 		buf.Seek(232)
+		return famProto
 	}
 
-	rawHdr.Len = uint16(buf.Len() - 16)
+	return 0
+}
 
-	buf.Seek(0)
-	err := binary.Write(buf, binary.BigEndian, rawHdr)
+// UnmarshalBinary parses a complete V2 header from data, replacing the contents of h.
+//
+// An error is returned if data contains anything beyond the header's declared length.
+func (h *HeaderV2) UnmarshalBinary(data []byte) error {
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	p, err := parseV2(r, ParseOptions{})
+	if err != nil {
+		return err
+	}
+	if r.Buffered() > 0 || br.Len() > 0 {
+		return errors.New("unexpected data after header")
+	}
+	*h = *p
+	return nil
+}
+
+// WriteTo will write the V2 header to w. Command must be CmdProxy
+// to send any address data.
+//
+// The header is written with a single call to w.Write and the returned count
+// is the number of bytes actually written, even on failure. A partially written
+// header can't be resumed on the same stream; to retry, the full header (see MarshalBinary)
+// must be sent from the beginning on a new connection.
+func (h HeaderV2) WriteTo(w io.Writer) (int64, error) {
+	data, err := h.MarshalBinary()
 	if err != nil {
 		return 0, err
 	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// WriteStreaming will write the V2 header to w, followed by each StreamTLV after
+// any TLVs already present on the header.
+//
+// The header length is computed up front from each StreamTLV's Len, so values can be
+// copied directly from their readers without being held in memory. An error is returned
+// if any reader provides fewer than Len bytes, or if a checksum is required (ComputeCRC is set or
+// TLVs contains a PP2TypeCRC32C TLV).
+func (h HeaderV2) WriteStreaming(w io.Writer, tlvs ...StreamTLV) (int64, error) {
+	if _, hasCRC := FindTLV(h, PP2TypeCRC32C); (h.ComputeCRC || hasCRC) && len(tlvs) > 0 {
+		return 0, errors.New("CRC32C can not be computed over streamed TLVs")
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	size := len(data) - 16
+	for _, t := range tlvs {
+		if t.Len < 0 || t.Len > 0xffff {
+			return 0, errors.New("invalid TLV length")
+		}
+		size += 3 + t.Len
+	}
+	if size > 0xffff {
+		return 0, ErrHeaderTooLong
+	}
+	binary.BigEndian.PutUint16(data[14:], uint16(size))
+
+	n, err := w.Write(data)
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	for _, t := range tlvs {
+		var tl [3]byte
+		tl[0] = byte(t.Type)
+		binary.BigEndian.PutUint16(tl[1:], uint16(t.Len))
+		n, err = w.Write(tl[:])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+
+		cn, err := io.CopyN(w, t.R, int64(t.Len))
+		total += cn
+		if err == io.EOF {
+			return total, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return total, err
+		}
+	}
 
-	return buf.WriteTo(w)
+	return total, nil
 }
@@ -3,9 +3,14 @@ package proxyprotocol
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -101,6 +106,25 @@ func TestHeaderV2(t *testing.T) {
 		},
 	)
 
+	check("udp-ipv4-port0", HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 0},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 53},
+	},
+		[]section{
+			{name: "Signature", value: sigV2},
+			{name: "Version", value: []byte{0x21}},   // v2, Proxy
+			{name: "Fam/Proto", value: []byte{0x12}}, // INET, DGRAM
+			{name: "Length", value: []byte{0, 12}},   // length=12
+
+			{name: "SrcAddr", value: []byte{192, 168, 0, 1}},
+			{name: "DestAddr", value: []byte{192, 168, 0, 2}},
+
+			{name: "SrcPort", value: []byte{0, 0}},
+			{name: "DstPort", value: []byte{0, 53}},
+		},
+	)
+
 	check("udp-ipv6", HeaderV2{
 		Command: CmdProxy,
 		Src:     &net.UDPAddr{IP: net.ParseIP("2001::1"), Port: 80},
@@ -153,3 +177,707 @@ func TestHeaderV2(t *testing.T) {
 	)
 
 }
+
+func TestHeaderV2_UnixRoundTrip(t *testing.T) {
+	for _, network := range []string{"unix", "unixgram"} {
+		h := HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.UnixAddr{Net: network, Name: "/run/src.sock"},
+			Dest:    &net.UnixAddr{Net: network, Name: "/run/dst.sock"},
+		}
+		var buf bytes.Buffer
+		_, err := h.WriteTo(&buf)
+		assert.NoError(t, err)
+
+		p, err := Parse(bufio.NewReader(&buf))
+		if !assert.NoError(t, err, network) {
+			continue
+		}
+		assert.Equal(t, "/run/src.sock", p.SrcAddr().String(), network)
+		assert.Equal(t, "/run/dst.sock", p.DestAddr().String(), network)
+	}
+}
+
+// TestHeaderV2_UnixTLVs checks headers at and beyond the 232-byte UNIX address boundary, where
+// parsing must grow beyond the default buffer size.
+func TestHeaderV2_UnixTLVs(t *testing.T) {
+	for _, size := range []int{0, 1, 13, 100, 0xffff - 216 - 3} {
+		var tlvs []TLV
+		if size > 0 {
+			tlvs = []TLV{{Type: PP2TypeAuthority, Value: bytes.Repeat([]byte{'a'}, size)}}
+		}
+		h := HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.UnixAddr{Net: "unix", Name: "/run/src.sock"},
+			Dest:    &net.UnixAddr{Net: "unix", Name: "/run/dst.sock"},
+			TLVs:    tlvs,
+		}
+		data, err := h.MarshalBinary()
+		if !assert.NoError(t, err, size) {
+			continue
+		}
+		expLen := 216
+		if size > 0 {
+			expLen += 3 + size
+		}
+		assert.Equal(t, 16+expLen, len(data), size)
+
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("hello")))
+		p, raw, err := ParseRaw(r)
+		if !assert.NoError(t, err, size) {
+			continue
+		}
+		assert.Equal(t, data, raw, size)
+		assert.True(t, Equal(h, p), size)
+		rest, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(rest), size)
+
+		// truncated within the TLVs
+		_, err = Parse(bufio.NewReader(bytes.NewReader(data[:len(data)-1])))
+		if assert.IsType(t, &InvalidHeaderErr{}, err, size) {
+			assert.Equal(t, data[:len(data)-1], err.(*InvalidHeaderErr).Read, size)
+		}
+	}
+}
+
+func TestHeaderV2_SetSource_SetDest(t *testing.T) {
+	tcp := func(ip string, port int) *net.TCPAddr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: port} }
+	udp := func(ip string, port int) *net.UDPAddr { return &net.UDPAddr{IP: net.ParseIP(ip), Port: port} }
+
+	check := func(desc string, h HeaderV2, fam AddrFamily, proto Proto) {
+		t.Helper()
+		f, p := h.FamilyProto()
+		assert.Equal(t, fam, f, desc)
+		assert.Equal(t, proto, p, desc)
+		assert.NoError(t, h.Validate(), desc)
+	}
+
+	h := HeaderV2{Command: CmdProxy, Src: tcp("1.2.3.4", 1), Dest: tcp("5.6.7.8", 2)}
+	check("tcp4", h, AddrFamilyInet, ProtoStream)
+
+	dest := h.Dest
+	h.SetSource(udp("1.2.3.4", 1))
+	check("udp4 source", h, AddrFamilyInet, ProtoDGram)
+	assert.Equal(t, "5.6.7.8:2", h.Dest.String())
+	assert.IsType(t, &net.TCPAddr{}, dest, "original address unchanged")
+
+	h.SetDest(udp("::1", 2))
+	check("udp mixed", h, AddrFamilyInet6, ProtoDGram)
+	assert.True(t, h.ForceInet6)
+	data, err := h.MarshalBinary()
+	if assert.NoError(t, err) {
+		p, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		assert.NoError(t, err)
+		assert.True(t, Equal(&h, p), "round trip")
+		assert.Equal(t, "1.2.3.4:1", p.SrcAddr().String())
+		assert.Equal(t, "[::1]:2", p.DestAddr().String())
+	}
+
+	h.SetDest(tcp("::1", 2))
+	h.SetSource(tcp("::2", 1))
+	check("tcp6", h, AddrFamilyInet6, ProtoStream)
+	assert.False(t, h.ForceInet6)
+
+	h.SetDest(udp("::ffff:5.6.7.8", 2))
+	h.SetSource(udp("1.2.3.4", 1))
+	check("udp4 mapped", h, AddrFamilyInet, ProtoDGram)
+	h.SetSource(tcp("::ffff:1.2.3.4", 1))
+	check("tcp4 mapped", h, AddrFamilyInet, ProtoStream)
+	assert.IsType(t, &net.TCPAddr{}, h.Dest)
+
+	h.SetSource(&net.UnixAddr{Net: "unix", Name: "/src"})
+	h.SetDest(&net.UnixAddr{Net: "unixgram", Name: "/dst"})
+	check("unixgram", h, AddrFamilyUnix, ProtoDGram)
+	assert.Equal(t, "/src", h.Src.String())
+
+	h.SetSource(nil)
+	h.SetDest(nil)
+	check("unspec", h, AddrFamilyUnspec, ProtoUnspec)
+}
+
+func TestHeaderV2_MarshalBinary_TooLong(t *testing.T) {
+	big := make([]byte, 0xffff)
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+		// 12 bytes of addresses + 3 + 0xffff would wrap to 14 if truncated
+		TLVs: []TLV{{Type: PP2TypeNOOP, Value: big}},
+	}
+	_, err := h.MarshalBinary()
+	assert.Equal(t, ErrHeaderTooLong, err)
+	var buf bytes.Buffer
+	n, err := h.WriteTo(&buf)
+	assert.Equal(t, ErrHeaderTooLong, err)
+	assert.EqualValues(t, 0, n)
+	assert.Equal(t, 0, buf.Len(), "nothing written")
+
+	// largest possible header
+	h.TLVs[0].Value = big[:0xffff-12-3]
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, MaxHeaderSize(), len(data))
+	p, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, Equal(h, p))
+
+	// a parsed header with the largest Trailing data can't be written with another TLV
+	v2 := p.(*HeaderV2)
+	assert.Len(t, v2.Trailing, 0xffff-12)
+	v2.TLVs = append(v2.TLVs, TLV{Type: PP2TypeNOOP})
+	_, err = v2.MarshalBinary()
+	assert.Equal(t, ErrHeaderTooLong, err)
+}
+
+func TestHeaderV2_MappedInet6RoundTrip(t *testing.T) {
+	data := append([]byte{}, sigV2...)
+	data = append(data, 0x21, 0x21, 0, 36) // PROXY, TCP over IPv6
+	data = append(data, net.ParseIP("::ffff:192.168.0.1").To16()...)
+	data = append(data, net.ParseIP("2001:db8::1").To16()...)
+	data = append(data, 0x04, 0xd2, 0x16, 0x2e)
+
+	check := func(name string, data []byte) {
+		t.Helper()
+		h, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		if !assert.NoError(t, err, name) {
+			return
+		}
+		v2, _ := AsV2(h)
+		assert.True(t, v2.ForceInet6, name)
+		fam, _ := v2.FamilyProto()
+		assert.Equal(t, AddrFamilyInet6, fam, name)
+		assert.Contains(t, v2.String(), " TCP6 ", name)
+
+		out, err := v2.MarshalBinary()
+		assert.NoError(t, err, name)
+		assert.Equal(t, data, out, name)
+	}
+	check("mixed", data)
+
+	// both mapped
+	copy(data[32:48], net.ParseIP("::ffff:192.168.0.2").To16())
+	check("mapped", data)
+
+	// mixed families are still rejected unless forced
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5678},
+	}
+	assert.True(t, errors.Is(h.Validate(), ErrAddrFamilyMismatch))
+	h.ForceInet6 = true
+	assert.NoError(t, h.Validate())
+}
+
+type failWriter struct {
+	n int
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// countingWriter records the number of calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	return w.Buffer.Write(p)
+}
+
+func TestHeader_WriteTo_SingleWrite(t *testing.T) {
+	check := func(name string, h Header) {
+		t.Helper()
+		var w countingWriter
+		n, err := h.WriteTo(&w)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1, w.calls, name)
+		assert.EqualValues(t, w.Len(), n, name)
+
+		p, err := Parse(bufio.NewReader(&w.Buffer))
+		assert.NoError(t, err, name)
+		assert.True(t, Equal(h, p), name)
+	}
+
+	check("v1", &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		SrcPort:  1234,
+		DestIP:   net.ParseIP("192.168.0.2"),
+		DestPort: 5678,
+	})
+	check("v2-tlvs", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("::2"), Port: 5678},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("example.com")},
+		},
+	})
+	check("v2-unix", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "/run/src.sock"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: "/run/dst.sock"},
+	})
+}
+
+func TestHeaderV2_UnmarshalBinary(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2").To4(), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeAuthority, Value: []byte("example.com")}},
+	}
+	data, err := h.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var p HeaderV2
+	assert.NoError(t, p.UnmarshalBinary(data))
+	h.Trailing = data[16+12:]
+	assert.Equal(t, h, p)
+
+	assert.Error(t, p.UnmarshalBinary(append(data, 0)), "trailing data")
+	assert.Error(t, p.UnmarshalBinary(data[:len(data)-1]), "short data")
+	assert.Error(t, p.UnmarshalBinary([]byte("PROXY UNKNOWN\r\n")), "v1 data")
+}
+
+func TestHeaderV2_WriteTo_Interrupted(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+	}
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, 28)
+
+	n, err := h.WriteTo(&failWriter{n: 10})
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.EqualValues(t, 10, n)
+
+	// resume by re-sending the full header
+	var buf bytes.Buffer
+	n, err = h.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, data, buf.Bytes())
+}
+
+func TestParseWithOptions_IncludeLocalAddrs(t *testing.T) {
+	data := append([]byte{}, sigV2...)
+	data = append(data,
+		0x20,  // v2, Local
+		0x11,  // INET, STREAM
+		0, 12, // length=12
+		192, 168, 0, 1,
+		192, 168, 0, 2,
+		0, 80,
+		0, 90,
+	)
+
+	hdr, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	if assert.NoError(t, err) {
+		h := hdr.(*HeaderV2)
+		assert.Nil(t, h.Src)
+		assert.Nil(t, h.Dest)
+		assert.Nil(t, h.RawSrc)
+		assert.Nil(t, h.RawDest)
+	}
+
+	hdr, err = ParseWithOptions(bufio.NewReader(bytes.NewReader(data)), ParseOptions{IncludeLocalAddrs: true})
+	if assert.NoError(t, err) {
+		h := hdr.(*HeaderV2)
+		assert.Equal(t, CmdLocal, h.Command)
+		assert.Nil(t, h.SrcAddr())
+		assert.Nil(t, h.DestAddr())
+		assert.Equal(t, "192.168.0.1:80", h.RawSrc.String())
+		assert.Equal(t, "192.168.0.2:90", h.RawDest.String())
+	}
+
+	// Conn must not use the raw addresses
+	src, dst := net.Pipe()
+	defer src.Close()
+	defer dst.Close()
+	go src.Write(data)
+	c := NewConn(dst, time.Now().Add(time.Second))
+	assert.Equal(t, "pipe", c.RemoteAddr().String())
+	assert.Equal(t, "pipe", c.LocalAddr().String())
+}
+
+func TestParseWithOptions_MaxV2Size(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeNOOP, Value: make([]byte, 100)}},
+	}
+	data, err := h.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = ParseWithOptions(bufio.NewReader(bytes.NewReader(data)), ParseOptions{MaxV2Size: len(data)})
+	assert.NoError(t, err, "exact size")
+
+	// only the fixed 16 bytes are needed to reject the header
+	_, err = ParseWithOptions(bufio.NewReader(bytes.NewReader(data[:16])), ParseOptions{MaxV2Size: len(data) - 1})
+	if assert.IsType(t, &InvalidHeaderErr{}, err) {
+		assert.Equal(t, ErrHeaderTooLong, err.(*InvalidHeaderErr).error)
+		assert.Len(t, err.(*InvalidHeaderErr).Read, 16)
+	}
+}
+
+func TestParse_NoStdout(t *testing.T) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeNOOP}},
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	_, err = Parse(bufio.NewReader(bytes.NewReader(data)))
+	os.Stdout = stdout
+	w.Close()
+	assert.NoError(t, err)
+
+	out, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Empty(t, string(out))
+}
+
+func TestHeaderV2_String(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN},
+			{Type: PP2TypeAuthority},
+			{Type: PP2TypeCRC32C},
+		},
+	}
+	assert.Equal(t, "PROXY v2 PROXY TCP4 1.2.3.4:1234 -> 5.6.7.8:5678 [ALPN Authority CRC32C]", h.String())
+
+	h = HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unixgram", Name: "/a"},
+		Dest:    &net.UnixAddr{Net: "unixgram", Name: "/b"},
+	}
+	assert.Equal(t, "PROXY v2 PROXY UNIX_DGRAM /a -> /b []", h.String())
+
+	assert.Equal(t, "PROXY v2 LOCAL UNSPEC - -> - []", HeaderV2{}.String())
+}
+
+func TestHeaderV2_Validate(t *testing.T) {
+	ip4 := net.ParseIP("192.168.0.1")
+	ip6 := net.ParseIP("::1")
+	check := func(name string, src, dst net.Addr, valid bool) {
+		t.Helper()
+		h := HeaderV2{Command: CmdProxy, Src: src, Dest: dst}
+		err := h.Validate()
+		if valid {
+			assert.NoError(t, err, name)
+			return
+		}
+		assert.Error(t, err, name)
+		_, err = h.WriteTo(ioutil.Discard)
+		assert.Error(t, err, name+" WriteTo")
+	}
+
+	check("nil", nil, nil, true)
+	check("tcp4", &net.TCPAddr{IP: ip4, Port: 1}, &net.TCPAddr{IP: ip4, Port: 2}, true)
+	check("udp6", &net.UDPAddr{IP: ip6}, &net.UDPAddr{IP: ip6}, true)
+	check("unix", &net.UnixAddr{Net: "unix", Name: "a"}, &net.UnixAddr{Net: "unix", Name: "b"}, true)
+
+	check("nil-src", nil, &net.TCPAddr{IP: ip4}, false)
+	check("nil-dst", &net.TCPAddr{IP: ip4}, nil, false)
+	check("tcp-udp", &net.TCPAddr{IP: ip4}, &net.UDPAddr{IP: ip4}, false)
+	check("ipv4-ipv6", &net.TCPAddr{IP: ip4}, &net.TCPAddr{IP: ip6}, false)
+	check("no-ip", &net.TCPAddr{}, &net.TCPAddr{IP: ip4}, false)
+	check("port", &net.TCPAddr{IP: ip4, Port: 70000}, &net.TCPAddr{IP: ip4}, false)
+	check("unix-net", &net.UnixAddr{Net: "unix", Name: "a"}, &net.UnixAddr{Net: "unixgram", Name: "b"}, false)
+	check("unix-name", &net.UnixAddr{Net: "unix", Name: strings.Repeat("a", 109)}, &net.UnixAddr{Net: "unix", Name: "b"}, false)
+	check("ip-addr", &net.IPAddr{IP: ip4}, &net.IPAddr{IP: ip4}, false)
+
+	err := HeaderV2{Command: CmdProxy, Src: &net.TCPAddr{IP: ip4}, Dest: &net.UDPAddr{IP: ip4}}.Validate()
+	assert.EqualError(t, err, "address type mismatch: destination address is *net.UDPAddr, expected *net.TCPAddr to match source")
+	assert.True(t, errors.Is(err, ErrAddrTypeMismatch))
+
+	err = HeaderV2{Command: CmdProxy, Src: &net.TCPAddr{IP: ip4}, Dest: &net.TCPAddr{IP: ip6}}.Validate()
+	assert.True(t, errors.Is(err, ErrAddrFamilyMismatch))
+
+	_, err = HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "a"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: strings.Repeat("b", 120)},
+	}.WriteTo(ioutil.Discard)
+	assert.True(t, errors.Is(err, ErrUnixPathTooLong))
+	assert.EqualError(t, err, "destination unix address name too long (max 108 bytes)")
+
+	// addresses are ignored for LOCAL
+	assert.NoError(t, HeaderV2{Src: &net.IPAddr{}}.Validate())
+}
+
+func TestHeaderV2_MarshalBinary_FamProto(t *testing.T) {
+	ip4 := net.ParseIP("192.168.0.1")
+	ip6 := net.ParseIP("2001:db8::1")
+	check := func(name string, src, dst net.Addr, famProto byte, length uint16) {
+		t.Helper()
+		data, err := HeaderV2{Command: CmdProxy, Src: src, Dest: dst}.MarshalBinary()
+		if !assert.NoError(t, err, name) {
+			return
+		}
+		assert.Equal(t, famProto, data[13], name+" Fam/Proto")
+		assert.Equal(t, []byte{byte(length >> 8), byte(length)}, data[14:16], name+" Length")
+	}
+
+	check("tcp4", &net.TCPAddr{IP: ip4}, &net.TCPAddr{IP: ip4}, 0x11, 12)
+	check("udp4", &net.UDPAddr{IP: ip4}, &net.UDPAddr{IP: ip4}, 0x12, 12)
+	check("tcp6", &net.TCPAddr{IP: ip6}, &net.TCPAddr{IP: ip6}, 0x21, 36)
+	check("udp6", &net.UDPAddr{IP: ip6}, &net.UDPAddr{IP: ip6}, 0x22, 36)
+	check("unix", &net.UnixAddr{Net: "unix"}, &net.UnixAddr{Net: "unix"}, 0x31, 216)
+	check("unixgram", &net.UnixAddr{Net: "unixgram"}, &net.UnixAddr{Net: "unixgram"}, 0x32, 216)
+	check("unspec", nil, nil, 0x00, 0)
+}
+
+func TestParse_V2_NoAlias(t *testing.T) {
+	parse := func(ip string) Header {
+		data, err := HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.TCPAddr{IP: net.ParseIP(ip), Port: 80},
+			Dest:    &net.TCPAddr{IP: net.ParseIP(ip), Port: 90},
+		}.MarshalBinary()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		h, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return h
+	}
+
+	h := parse("192.168.0.1")
+	for i := 0; i < 10; i++ {
+		parse("10.0.0.1")
+	}
+	assert.Equal(t, "192.168.0.1:80", h.SrcAddr().String())
+	assert.Equal(t, "192.168.0.1:90", h.DestAddr().String())
+}
+
+func BenchmarkParse_V2(b *testing.B) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeAuthority, Value: []byte("example.com")}},
+	}.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(data)
+		r.Reset(br)
+		_, err := Parse(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHeaderV2_LocalRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := HeaderV2{Command: CmdLocal}.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 16, n)
+	assert.Equal(t, append(append([]byte{}, sigV2...), 0x20, 0x00, 0, 0), buf.Bytes())
+
+	data := append(buf.Bytes(), "hello"...)
+	src, dst := net.Pipe()
+	defer dst.Close()
+	go func() {
+		src.Write(data)
+		src.Close()
+	}()
+
+	c := WrapConn(dst)
+	hdr, err := c.ProxyHeader()
+	if assert.NoError(t, err) {
+		h := hdr.(*HeaderV2)
+		assert.Equal(t, CmdLocal, h.Command)
+		assert.Nil(t, h.SrcAddr())
+		assert.Nil(t, h.DestAddr())
+		assert.True(t, Equal(HeaderV2{Command: CmdLocal}, h))
+	}
+	assert.Equal(t, dst.RemoteAddr(), c.RemoteAddr())
+	assert.Equal(t, dst.LocalAddr(), c.LocalAddr())
+
+	rest, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(rest))
+}
+
+func TestHeaderV2_Clone(t *testing.T) {
+	orig := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("example.com")},
+		},
+		Trailing: []byte{0x01, 0x00, 0x02, 'h', '2'},
+	}
+	c := orig.Clone()
+	assert.True(t, Equal(orig, c))
+	assert.Equal(t, orig.Trailing, c.Trailing)
+
+	c.TLVs[0].Value[0] = 'x'
+	c.TLVs[1] = TLV{Type: PP2TypeNOOP}
+	c.TLVs = append(c.TLVs, TLV{Type: PP2TypeNetNS, Value: []byte("ns")})
+	c.Src.(*net.TCPAddr).IP[len(c.Src.(*net.TCPAddr).IP)-1] = 9
+	c.Dest.(*net.TCPAddr).Port = 1
+	c.Trailing[3] = 'x'
+
+	assert.Equal(t, "h2", string(orig.TLVs[0].Value))
+	assert.Equal(t, PP2TypeAuthority, orig.TLVs[1].Type)
+	assert.Len(t, orig.TLVs, 2)
+	assert.Equal(t, "192.168.0.1:1234", orig.Src.String())
+	assert.Equal(t, "192.168.0.2:5678", orig.Dest.String())
+	assert.Equal(t, "h2", string(orig.Trailing[3:]))
+
+	u := HeaderV2{
+		Command: CmdLocal,
+		RawSrc:  &net.UnixAddr{Net: "unix", Name: "/tmp/a.sock"},
+		RawDest: &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53},
+	}
+	uc := u.Clone()
+	uc.RawSrc.(*net.UnixAddr).Name = "/tmp/b.sock"
+	uc.RawDest.(*net.UDPAddr).IP[0] = 0xff
+	assert.Equal(t, "/tmp/a.sock", u.RawSrc.String())
+	assert.Equal(t, "[::1]:53", u.RawDest.String())
+	assert.Nil(t, uc.Src)
+	assert.Nil(t, uc.TLVs)
+	assert.Nil(t, uc.Trailing)
+}
+
+type customAddr struct{}
+
+func (customAddr) Network() string { return "custom" }
+func (customAddr) String() string  { return "tunnel-1" }
+
+type customAddrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c customAddrConn) LocalAddr() net.Addr  { return c.local }
+func (c customAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestHeaderV2_FromConn_Unsupported(t *testing.T) {
+	check := func(name string, local, remote net.Addr, expAddrs bool) {
+		t.Helper()
+		var h HeaderV2
+		h.FromConn(customAddrConn{local: local, remote: remote}, false)
+		assert.Equal(t, CmdProxy, h.Command, name)
+		if expAddrs {
+			assert.Equal(t, remote, h.Src, name)
+			assert.Equal(t, local, h.Dest, name)
+		} else {
+			assert.Nil(t, h.Src, name)
+			assert.Nil(t, h.Dest, name)
+		}
+
+		var buf bytes.Buffer
+		_, err := h.WriteTo(&buf)
+		assert.NoError(t, err, name)
+		p, err := Parse(bufio.NewReader(&buf))
+		assert.NoError(t, err, name)
+		assert.True(t, Equal(h, p), name)
+	}
+
+	tcp := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234}
+	check("tcp", tcp, &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678}, true)
+	check("custom", customAddr{}, customAddr{}, false)
+	check("custom-remote", tcp, customAddr{}, false)
+	check("mismatch", tcp, &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678}, false)
+	check("nil", nil, nil, false)
+}
+
+func TestHeaderV2_UnspecTLVs(t *testing.T) {
+	for _, cmd := range []Cmd{CmdLocal, CmdProxy} {
+		h := HeaderV2{
+			Command: cmd,
+			TLVs:    []TLV{{Type: PP2TypeNOOP, Value: []byte("keepalive")}},
+		}
+		data, err := h.MarshalBinary()
+		if !assert.NoError(t, err, cmd) {
+			continue
+		}
+
+		// no address bytes, only the TLV
+		exp := append(append([]byte{}, sigV2...), 0x20|byte(cmd), 0x00, 0, 12, byte(PP2TypeNOOP), 0, 9)
+		exp = append(exp, "keepalive"...)
+		assert.Equal(t, exp, data, cmd)
+
+		p, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		if assert.NoError(t, err, cmd) {
+			assert.True(t, Equal(h, p), cmd)
+			assert.Nil(t, p.SrcAddr())
+			assert.Equal(t, h.TLVs, p.(*HeaderV2).TLVs)
+		}
+	}
+}
+
+func BenchmarkParseInto_V2(b *testing.B) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeAuthority, Value: []byte("example.com")}},
+	}.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	var h2 HeaderV2
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(data)
+		r.Reset(br)
+		_, err := ParseInto(r, nil, &h2)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -11,63 +11,497 @@ import (
 type Listener struct {
 	net.Listener
 
-	filter []Rule
-	t      time.Duration
+	filter   []Rule
+	t        time.Duration
+	preamble []byte
+	policy   Policy
+	mode     ParseMode
+	maxHdrs  int
+	maxV2    int
+	validate []Validator
+	onError  func(net.Conn, error)
+	onHeader func(net.Conn, Header)
+	eagerT   time.Duration
 
 	mx sync.RWMutex
+
+	// background accept loop, used once PolicyReject or ParseEager is in effect (see Accept)
+	initOnce  sync.Once
+	startOnce sync.Once
+	async     bool
+	results   chan acceptResult
+	slots     chan struct{}
+	done      chan struct{}
+	err       error
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	pmx       sync.Mutex
+	pending   map[net.Conn]struct{}
+}
+
+// maxPendingConns limits the connections accepted by the background accept loop that have not yet been
+// returned by Accept, including those still sending their header.
+const maxPendingConns = 64
+
+// Policy determines how a Listener handles PROXY headers for connections it wraps.
+type Policy int
+
+const (
+	// PolicyRequire requires a PROXY header. An invalid or missing header is returned as an
+	// error when reading from the connection.
+	PolicyRequire Policy = iota
+
+	// PolicyOptional will parse a PROXY header if one is present, otherwise the connection is used
+	// as-is with its real addresses. Detecting a header requires peeking at the first bytes sent.
+	PolicyOptional
+
+	// PolicyReject requires a PROXY header and closes connections that fail to provide a valid one.
+	// The header is read before the connection is returned by Accept (see Accept), as with ParseEager.
+	PolicyReject
+)
+
+// ParseMode determines when a Listener reads the PROXY header of the connections it wraps.
+type ParseMode int
+
+const (
+	// ParseLazy reads the header on first use of the connection (e.g. Read or RemoteAddr), from the
+	// goroutine using it. Accept returns immediately, so a slow or large header (e.g. a V2 header with
+	// many TLVs) only delays its own connection.
+	ParseLazy ParseMode = iota
+
+	// ParseEager reads the header before Accept returns the connection, within the timeout of each
+	// connection, so the returned connection has its addresses resolved and connections with invalid
+	// headers are never returned. Each header is read in its own goroutine, but connections waiting for
+	// their header are held by the Listener, so a zero timeout is treated as 10 seconds.
+	ParseEager
+)
+
+// defaultEagerTimeout is the timeout used instead of zero for connections whose header is read by Accept
+// (see ParseEager), so that idle connections are not held by the Listener indefinitely.
+const defaultEagerTimeout = 10 * time.Second
+
+// A ListenerOption configures a Listener when passed to NewListener.
+type ListenerOption func(*Listener)
+
+// WithTimeout sets the default timeout, equivalent to calling SetDefaultTimeout.
+func WithTimeout(t time.Duration) ListenerOption {
+	return func(l *Listener) { l.SetDefaultTimeout(t) }
+}
+
+// WithFilter sets the filter rules, equivalent to calling SetFilter.
+func WithFilter(filter []Rule) ListenerOption {
+	return func(l *Listener) { l.SetFilter(filter) }
+}
+
+// WithPreamble sets a shared secret to expect before the PROXY header, equivalent to calling SetPreamble.
+func WithPreamble(p []byte) ListenerOption {
+	return func(l *Listener) { l.SetPreamble(p) }
+}
+
+// WithPolicy sets the header policy, equivalent to calling SetPolicy.
+func WithPolicy(p Policy) ListenerOption {
+	return func(l *Listener) { l.SetPolicy(p) }
+}
+
+// WithParseMode sets when headers are read, equivalent to calling SetParseMode.
+func WithParseMode(m ParseMode) ListenerOption {
+	return func(l *Listener) { l.SetParseMode(m) }
+}
+
+// WithEager sets eager header parsing, equivalent to calling SetEager.
+func WithEager(eager bool) ListenerOption {
+	return func(l *Listener) { l.SetEager(eager) }
+}
+
+// WithMaxHeaders sets the maximum number of chained headers, equivalent to calling SetMaxHeaders.
+func WithMaxHeaders(n int) ListenerOption {
+	return func(l *Listener) { l.SetMaxHeaders(n) }
+}
+
+// WithMaxV2Size sets the maximum size of a V2 header, equivalent to calling SetMaxV2Size.
+func WithMaxV2Size(n int) ListenerOption {
+	return func(l *Listener) { l.SetMaxV2Size(n) }
+}
+
+// WithValidators sets the header validators, equivalent to calling SetValidators.
+func WithValidators(v ...Validator) ListenerOption {
+	return func(l *Listener) { l.SetValidators(v...) }
 }
 
 // NewListener will wrap nl, automatically handling PROXY headers for all connections.
-// To expect PROXY headers only from certain addresses/subnets, use SetFilter.
+// To expect PROXY headers only from certain addresses/subnets, use SetFilter or WithFilter.
 //
 // By default, all connections must provide a PROXY header within the specified timeout.
-func NewListener(nl net.Listener, t time.Duration) *Listener {
+// Options are applied in order after the timeout is set.
+func NewListener(nl net.Listener, t time.Duration, opts ...ListenerOption) *Listener {
 	l := &Listener{
 		Listener: nl,
 		t:        t,
+		eagerT:   defaultEagerTimeout,
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
 	return l
 }
 
 // Accept waits for and returns the next connection to the listener, wrapping it with NewConn if the RemoteAddr matches
 // any registered rules.
+//
+// With PolicyReject or ParseEager (see SetParseMode), the header of each matched connection is read in
+// its own goroutine, and the connection is returned by Accept once the header has been received, so a
+// slow client does not delay other connections. Connections that fail to provide a valid header are
+// closed, and errors reading the header are never returned by Accept; use OnError to observe them.
+//
+// To do so, connections are accepted from the underlying listener by a background goroutine, started the
+// first time Accept is called with either setting in effect, and used from then on. At most 64 connections
+// are held waiting for their header or for a call to Accept; further connections are left to the
+// underlying listener until one is returned or closed. Otherwise, Accept calls the underlying listener directly.
 func (l *Listener) Accept() (net.Conn, error) {
-	c, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
+	l.init()
+	if !l.isAsync() {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		conn, parse := l.wrap(c)
+		if !parse {
+			return conn, nil
+		}
+
+		// settings changed since checking, read the header in the background instead
+		l.slots <- struct{}{}
+		l.start()
+		l.handle(c, conn, true)
+	}
+
+	select {
+	case <-l.stop:
+	default:
+		select {
+		case res := <-l.results:
+			return res.c, res.err
+		case <-l.stop:
+		case <-l.done:
+			return nil, l.err
+		}
+	}
+
+	// closed, return the error from the underlying listener
+	<-l.done
+	return nil, l.err
+}
+
+// Close closes the underlying listener, along with any connections accepted in the background
+// that have not been returned by Accept.
+func (l *Listener) Close() error {
+	l.init()
+	l.closeOnce.Do(func() {
+		l.pmx.Lock()
+		close(l.stop)
+		for c := range l.pending {
+			c.Close()
+		}
+		l.pmx.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+type acceptResult struct {
+	c   net.Conn
+	err error
+}
+
+func (l *Listener) init() {
+	l.initOnce.Do(func() {
+		l.results = make(chan acceptResult)
+		l.slots = make(chan struct{}, maxPendingConns)
+		l.done = make(chan struct{})
+		l.stop = make(chan struct{})
+		l.pending = make(map[net.Conn]struct{})
+	})
+}
+
+// isAsync reports whether Accept should use the background accept loop, starting it if needed.
+func (l *Listener) isAsync() bool {
+	l.mx.RLock()
+	async := l.async || l.policy == PolicyReject || l.mode == ParseEager
+	l.mx.RUnlock()
+	if async {
+		l.start()
+	}
+	return async
+}
+
+// start starts the background accept loop, if it is not already running.
+func (l *Listener) start() {
+	l.startOnce.Do(func() {
+		l.mx.Lock()
+		l.async = true
+		l.mx.Unlock()
+		go l.acceptLoop()
+	})
+}
+
+// acceptLoop accepts connections from the underlying listener, sending them to Accept. A slot is
+// taken for each connection, and released once it has been returned by Accept or closed.
+func (l *Listener) acceptLoop() {
+	for {
+		l.slots <- struct{}{}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.slots
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				select {
+				case l.results <- acceptResult{err: err}:
+				case <-l.stop:
+				}
+				continue
+			}
+			l.err = err
+			close(l.done)
+			return
+		}
+
+		conn, parse := l.wrap(c)
+		l.handle(c, conn, parse)
+	}
+}
+
+// handle sends conn, wrapping c, to Accept, first reading the header in a new goroutine if parse is set.
+// The caller must hold a slot for c.
+func (l *Listener) handle(c, conn net.Conn, parse bool) {
+	if !parse {
+		l.send(conn)
+		return
+	}
+
+	// track c until it is handed off, so Close can interrupt reading the header
+	l.pmx.Lock()
+	select {
+	case <-l.stop:
+		l.pmx.Unlock()
+		c.Close()
+		<-l.slots
+		return
+	default:
+	}
+	l.pending[c] = struct{}{}
+	l.pmx.Unlock()
+
+	go func() {
+		err := conn.(*Conn).readHeader()
+		l.pmx.Lock()
+		delete(l.pending, c)
+		l.pmx.Unlock()
+		if err != nil {
+			// never return per-connection errors, servers stop on non-temporary Accept errors
+			c.Close()
+			<-l.slots
+			return
+		}
+		l.send(conn)
+	}()
+}
+
+// send hands c to a pending Accept call, closing it if the listener has been closed or has stopped,
+// and releases its slot.
+func (l *Listener) send(c net.Conn) {
+	select {
+	case l.results <- acceptResult{c: c}:
+	case <-l.stop:
+		c.Close()
+	case <-l.done:
+		c.Close()
 	}
+	<-l.slots
+}
 
+// wrap wraps c according to the current settings, reporting whether the header must be read before it
+// is returned by Accept.
+func (l *Listener) wrap(c net.Conn) (net.Conn, bool) {
 	l.mx.RLock()
 	filter := l.filter
 	t := l.t
+	preamble := l.preamble
+	policy := l.policy
+	mode := l.mode
+	maxHdrs := l.maxHdrs
+	maxV2 := l.maxV2
+	validate := l.validate
+	onError, onHeader := l.onError, l.onHeader
+	eagerT := l.eagerT
 	l.mx.RUnlock()
 
-	if len(filter) == 0 {
-		if t == 0 {
-			return NewConn(c, time.Time{}), nil
+	if len(filter) > 0 {
+		rule, ok := matchRule(filter, c.RemoteAddr())
+		if !ok {
+			return c, false
+		}
+		t = rule.Timeout
+		if rule.Optional {
+			policy = PolicyOptional
 		}
-		return NewConn(c, time.Now().Add(t)), nil
 	}
 
-	var remoteIP net.IP
-	switch r := c.RemoteAddr().(type) {
-	case *net.TCPAddr:
-		remoteIP = r.IP
-	case *net.UDPAddr:
-		remoteIP = r.IP
-	default:
-		return c, nil
+	parse := policy == PolicyReject || mode == ParseEager
+	if parse && t == 0 {
+		t = eagerT
+	}
+
+	var deadline time.Time
+	if t != 0 {
+		deadline = time.Now().Add(t)
+	}
+	conn := NewConn(c, deadline)
+	conn.preamble = preamble
+	conn.optional = policy == PolicyOptional
+	conn.maxHeaders = maxHdrs
+	conn.opts.MaxV2Size = maxV2
+	conn.validators = validate
+	if onError != nil {
+		conn.onError = func(err error) { onError(c, err) }
+	}
+	if onHeader != nil {
+		conn.onHeader = func(h Header) { onHeader(c, h) }
+	}
+
+	if parse && maxHdrs <= 1 {
+		// the header can be reused once the conn is closed, unless it has been handed out (see Conn.Close)
+		conn.pool = &headerV2Pool
+	}
+
+	return conn, parse
+}
+
+// headerV2Pool provides headers for connections that read their header before being returned by Accept.
+var headerV2Pool = sync.Pool{New: func() interface{} { return new(HeaderV2) }}
+
+// matchRule returns the first rule in filter matching addr.
+func matchRule(filter []Rule, addr net.Addr) (Rule, bool) {
+	remoteIP := addrIP(addr)
+	if remoteIP == nil {
+		return Rule{}, false
 	}
 
 	for _, n := range filter {
 		if n.Subnet.Contains(remoteIP) {
-			if n.Timeout == 0 {
-				return NewConn(c, time.Time{}), nil
-			}
-			return NewConn(c, time.Now().Add(n.Timeout)), nil
+			return n, true
 		}
 	}
-	return c, nil
+	return Rule{}, false
+}
+
+// SetParseMode sets when the PROXY header of matched connections is read. The default is ParseLazy.
+// With PolicyReject, headers are always read during Accept regardless of the mode.
+//
+// With ParseEager, if reading the header fails, the connection is closed and Accept waits for the next
+// connection, as with PolicyReject. The error is only reported to the OnError callback, so a client sending
+// an invalid header can't cause servers such as http.Server (which stop serving when Accept returns an error)
+// to exit.
+//
+// Eager parsing is not needed to use a Listener with http.Server, which calls RemoteAddr (reading
+// the header) from each connection's own goroutine before handling any requests.
+//
+// SetParseMode is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetParseMode(m ParseMode) {
+	l.mx.Lock()
+	l.mode = m
+	l.mx.Unlock()
+}
+
+// SetEager is equivalent to SetParseMode(ParseEager) if eager is set, or SetParseMode(ParseLazy) otherwise.
+func (l *Listener) SetEager(eager bool) {
+	if eager {
+		l.SetParseMode(ParseEager)
+	} else {
+		l.SetParseMode(ParseLazy)
+	}
+}
+
+// SetMaxHeaders allows up to n consecutive PROXY headers per connection, for topologies where
+// traffic passes through more than one proxy that each add a header. The default, 0 or 1, reads a single header.
+//
+// Headers are read as described by ParseAll, and the addresses of the innermost header are used for
+// LocalAddr and RemoteAddr, skipping any that do not provide them (e.g. a V2 LOCAL header). All headers
+// are available from Conn.ProxyHeaders. Since checking for another header requires data from the client,
+// this should not be used with protocols where the server speaks first.
+//
+// SetMaxHeaders is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetMaxHeaders(n int) {
+	l.mx.Lock()
+	l.maxHdrs = n
+	l.mx.Unlock()
+}
+
+// SetMaxV2Size limits the size of V2 headers, including the 16-byte preamble, to n bytes. Larger
+// headers are rejected with ErrHeaderTooLong as soon as their length is read, without waiting for
+// the rest of the header (see ParseOptions.MaxV2Size). The default, 0, allows any size.
+//
+// SetMaxV2Size is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetMaxV2Size(n int) {
+	l.mx.Lock()
+	l.maxV2 = n
+	l.mx.Unlock()
+}
+
+// SetValidators sets validators to check each header received, in order, after it is parsed
+// (see ValidateHeader). With multiple headers (see SetMaxHeaders), the innermost header is checked.
+//
+// A header rejected by a validator is treated as invalid: an InvalidHeaderErr wrapping the validator's
+// error is returned when reading from the connection, and with PolicyReject or ParseEager the connection
+// is closed before being returned by Accept. In either case the error is passed to the OnError callback.
+//
+// SetValidators is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetValidators(v ...Validator) {
+	l.mx.Lock()
+	l.validate = v
+	l.mx.Unlock()
+}
+
+// MaxHeaderSize returns the largest number of bytes a single PROXY header may occupy on connections
+// accepted by l, taking SetMaxV2Size into account (see ParseOptions.MaxHeaderSize).
+func (l *Listener) MaxHeaderSize() int {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return ParseOptions{MaxV2Size: l.maxV2}.MaxHeaderSize()
+}
+
+// OnError sets fn to be called whenever reading the PROXY header fails for a wrapped connection.
+//
+// The header is read on first use of the connection (or during Accept with PolicyReject or ParseEager), so fn
+// is called from that goroutine and delays it until fn returns. The underlying connection is
+// passed as c and should not be read from.
+//
+// OnError is safe to call from multiple goroutines while the listener is in use, but only
+// affects connections accepted afterwards.
+func (l *Listener) OnError(fn func(c net.Conn, err error)) {
+	l.mx.Lock()
+	l.onError = fn
+	l.mx.Unlock()
+}
+
+// OnHeader sets fn to be called whenever a PROXY header is successfully read for a wrapped connection.
+//
+// It is called under the same conditions as an OnError callback.
+func (l *Listener) OnHeader(fn func(c net.Conn, h Header)) {
+	l.mx.Lock()
+	l.onHeader = fn
+	l.mx.Unlock()
+}
+
+// SetPolicy sets how connections matching the filter (or all connections, if the filter is nil)
+// handle PROXY headers. The default is PolicyRequire.
+//
+// Connections not matching any filter rule are always returned directly, regardless of policy, and
+// rules may make the header optional for their subnet (see Rule.Optional).
+//
+// SetPolicy is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetPolicy(p Policy) {
+	l.mx.Lock()
+	l.policy = p
+	l.mx.Unlock()
 }
 
 // SetDefaultTimeout sets the default timeout, used when the subnet filter is nil.
@@ -79,6 +513,20 @@ func (l *Listener) SetDefaultTimeout(t time.Duration) {
 	l.mx.Unlock()
 }
 
+// SetPreamble sets a shared secret that must be sent before the PROXY header.
+//
+// Connections that would be wrapped must send exactly these bytes first, otherwise
+// reading from the connection returns an InvalidHeaderErr. This provides a simple filter
+// against clients other than the expected proxy. A nil or empty preamble disables the check (the default).
+//
+// SetPreamble is safe to call from multiple goroutines while the listener is in use.
+func (l *Listener) SetPreamble(p []byte) {
+	preamble := append([]byte(nil), p...)
+	l.mx.Lock()
+	l.preamble = preamble
+	l.mx.Unlock()
+}
+
 // Filter returns the current set of filter rules.
 //
 // Filter is safe to call from multiple goroutines while the listener is in use.
@@ -95,13 +543,25 @@ func (l *Listener) Filter() []Rule {
 // If filter is nil, all connections will be required to provide a PROXY header (the default).
 //
 // Connections not matching any rule will be returned directly without reading a PROXY header.
+// IPv4 rules also match IPv4-mapped IPv6 remote addresses (e.g. ::ffff:1.2.3.4). A Subnet with a 4-byte
+// mask may use either the 4 or 16-byte form of its IP (e.g. from net.ParseIP).
 //
 // Duplicate subnet rules will automatically be removed and the lowest non-zero timeout will be used.
+// The merged rule is only Optional if all of the duplicates are.
 //
 // SetFilter is safe to call from multiple goroutines while the listener is in use.
 func (l *Listener) SetFilter(filter []Rule) {
 	newFilter := make([]Rule, len(filter))
 	copy(newFilter, filter)
+	for i, r := range newFilter {
+		// store IPv4 subnets in the 4-byte form returned by net.ParseCIDR (and Filter)
+		if r.Subnet == nil || len(r.Subnet.Mask) != net.IPv4len || len(r.Subnet.IP) == net.IPv4len {
+			continue
+		}
+		if ip := r.Subnet.IP.To4(); ip != nil {
+			newFilter[i].Subnet = &net.IPNet{IP: ip, Mask: r.Subnet.Mask}
+		}
+	}
 	sort.Slice(newFilter, func(i, j int) bool {
 		iOnes, iBits := newFilter[i].Subnet.Mask.Size()
 		jOnes, jBits := newFilter[j].Subnet.Mask.Size()
@@ -111,26 +571,28 @@ func (l *Listener) SetFilter(filter []Rule) {
 		if iBits != jBits {
 			return iBits > jBits
 		}
-		if newFilter[i].Timeout != newFilter[j].Timeout {
-			if newFilter[j].Timeout == 0 {
-				return true
-			}
-			return newFilter[i].Timeout < newFilter[j].Timeout
+		iNet, jNet := newFilter[i].Subnet.String(), newFilter[j].Subnet.String()
+		if iNet != jNet {
+			return iNet < jNet
 		}
-		return newFilter[i].Timeout < newFilter[j].Timeout
+		// lowest non-zero timeout first
+		iT, jT := newFilter[i].Timeout, newFilter[j].Timeout
+		if iT == 0 || jT == 0 {
+			return jT == 0 && iT != 0
+		}
+		return iT < jT
 	})
 	if len(newFilter) > 0 {
-		// dedup
-		last := newFilter[0]
-		nf := newFilter[1:1]
+		// dedup, keeping the first (lowest non-zero timeout) rule for each subnet
+		nf := newFilter[:1]
 		for _, f := range newFilter[1:] {
-			if last.Subnet.String() == f.Subnet.String() {
+			if last := &nf[len(nf)-1]; last.Subnet.String() == f.Subnet.String() {
+				last.Optional = last.Optional && f.Optional
 				continue
 			}
-
-			last = f
 			nf = append(nf, f)
 		}
+		newFilter = nf
 	}
 
 	l.mx.Lock()
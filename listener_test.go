@@ -1,8 +1,17 @@
 package proxyprotocol
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,6 +41,58 @@ func ExampleNewListener() {
 	}
 }
 
+func ExampleListener_httpServer() {
+	nl, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		log.Println("ERROR: listen:", err)
+		return
+	}
+
+	// The header is read from each connection's own goroutine, when the server
+	// first calls RemoteAddr, so slow clients don't block Accept.
+	l := NewListener(nl, 3*time.Second)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// RemoteAddr will be the source address of the PROXY header
+			io.WriteString(w, req.RemoteAddr)
+		}),
+	}
+	log.Println(srv.Serve(l))
+}
+
+func TestListener_HTTP(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := NewListener(nl, time.Second)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			io.WriteString(w, req.RemoteAddr)
+		}),
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	io.WriteString(c, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 80\r\n")
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", string(body))
+}
+
 func TestListener_TCPV1(t *testing.T) {
 	nl, err := net.Listen("tcp", ":0")
 	assert.NoError(t, err)
@@ -120,3 +181,871 @@ func TestListener_TCPV2(t *testing.T) {
 	}
 
 }
+
+type chanListener chan net.Conn
+
+func (l chanListener) Accept() (net.Conn, error) {
+	c, ok := <-l
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return c, nil
+}
+func (l chanListener) Close() error   { return nil }
+func (l chanListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func BenchmarkListener_Accept(b *testing.B) {
+	hdr, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+	}.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	bench := func(b *testing.B, mode ParseMode, pool bool) {
+		nl := make(chanListener, 1)
+		defer close(nl)
+		l := NewListener(nl, time.Second, WithParseMode(mode))
+		if !pool {
+			// headers passed to OnHeader are never reused
+			l.OnHeader(func(net.Conn, Header) {})
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			src, dst := net.Pipe()
+			go src.Write(hdr)
+			nl <- dst
+			c, err := l.Accept()
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.RemoteAddr()
+			c.Close()
+			src.Close()
+		}
+	}
+	b.Run("lazy", func(b *testing.B) { bench(b, ParseLazy, false) })
+	b.Run("eager", func(b *testing.B) { bench(b, ParseEager, false) })
+	b.Run("eager-pooled", func(b *testing.B) { bench(b, ParseEager, true) })
+}
+
+func TestListener_HeaderPoolAllocs(t *testing.T) {
+	hdr, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	allocs := func(pool *sync.Pool) float64 {
+		return testing.AllocsPerRun(100, func() {
+			c := NewConn(readerConn{Reader: bytes.NewReader(hdr)}, time.Time{})
+			c.pool = pool
+			if err := c.readHeader(); err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+		})
+	}
+	unpooled, pooled := allocs(nil), allocs(&headerV2Pool)
+	assert.True(t, pooled < unpooled, "expected fewer allocs with pooling, got %v pooled, %v unpooled", pooled, unpooled)
+}
+
+// readerConn is a net.Conn reading from a Reader.
+type readerConn struct {
+	net.Conn
+	io.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+func (readerConn) Close() error                 { return nil }
+
+func TestListener_HeaderPool(t *testing.T) {
+	nl := make(chanListener, 1)
+	defer close(nl)
+	l := NewListener(nl, time.Second, WithParseMode(ParseEager))
+
+	accept := func(src string) *Conn {
+		data, err := HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.TCPAddr{IP: net.ParseIP(src), Port: 1234},
+			Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+			TLVs:    []TLV{{Type: PP2TypeAuthority, Value: []byte(src)}},
+		}.MarshalBinary()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		a, b := net.Pipe()
+		go a.Write(data)
+		nl <- b
+		c, err := l.Accept()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return c.(*Conn)
+	}
+
+	// handed out before Close, never reused
+	c := accept("192.168.0.1")
+	h, err := c.ProxyHeader()
+	assert.NoError(t, err)
+	c.Close()
+
+	for i := 0; i < 10; i++ {
+		// not handed out, may be reused after Close
+		c = accept("192.168.1.1")
+		c.Close()
+		assert.Equal(t, "192.168.1.1:1234", c.RemoteAddr().String(), "addresses unaffected")
+		h2, err := c.ProxyHeader()
+		assert.Error(t, err, "released on Close")
+		assert.Nil(t, h2, "released on Close")
+	}
+
+	// passed to OnHeader or a validator, never reused
+	var kept []Header
+	l.OnHeader(func(_ net.Conn, h Header) { kept = append(kept, h) })
+	accept("192.168.2.1").Close()
+	l.OnHeader(nil)
+	l.SetValidators(func(h Header) error {
+		kept = append(kept, h)
+		return nil
+	})
+	accept("192.168.2.2").Close()
+	l.SetValidators()
+	for i := 0; i < 10; i++ {
+		accept("192.168.1.1").Close()
+	}
+	if assert.Len(t, kept, 2) {
+		assert.Equal(t, "192.168.2.1:1234", kept[0].SrcAddr().String())
+		assert.Equal(t, "192.168.2.2:1234", kept[1].SrcAddr().String())
+	}
+
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+	auth, _ := FindTLV(h, PP2TypeAuthority)
+	assert.Equal(t, "192.168.0.1", string(auth))
+}
+
+func TestListener_UnixAbstract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxyprotocol")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	nl, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer nl.Close()
+
+	l := NewListener(nl, time.Second)
+
+	errCh := make(chan error, 2)
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		c, err := net.Dial("unix", l.Addr().String())
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer c.Close()
+
+		HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.UnixAddr{Net: "unix", Name: "\x00client"},
+			Dest:    &net.UnixAddr{Net: "unix", Name: "\x00server"},
+		}.WriteTo(c)
+	}()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errCh <- err
+		}
+		connCh <- c
+	}()
+
+	timeout := time.NewTimer(time.Second)
+	select {
+	case <-timeout.C:
+		t.Error("timeout waiting for connection")
+	case err := <-errCh:
+		t.Error(err)
+	case c := <-connCh:
+		defer c.Close()
+		assert.Equal(t, &net.UnixAddr{Net: "unix", Name: "\x00client"}, c.RemoteAddr(), "SrcAddr")
+		assert.Equal(t, &net.UnixAddr{Net: "unix", Name: "\x00server"}, c.LocalAddr(), "DestAddr")
+	}
+}
+
+func TestListener_Preamble(t *testing.T) {
+	hdr := "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	check := func(name, send string, valid bool) {
+		t.Run(name, func(t *testing.T) {
+			nl := make(chanListener, 1)
+			l := NewListener(nl, time.Second)
+			l.SetPreamble([]byte("secret"))
+
+			src, dst := net.Pipe()
+			defer src.Close()
+			go io.WriteString(src, send)
+			nl <- dst
+
+			c, err := l.Accept()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+
+			_, err = c.(*Conn).ProxyHeader()
+			if valid {
+				assert.NoError(t, err)
+				assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+			} else {
+				assert.IsType(t, &InvalidHeaderErr{}, err)
+				assert.Equal(t, "pipe", c.RemoteAddr().String())
+			}
+		})
+	}
+
+	check("correct", "secret"+hdr, true)
+	check("wrong", "public"+hdr, false)
+	check("none", hdr, false)
+}
+
+func TestNewListener_Options(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.0.0/24")
+	if !assert.NoError(t, err) {
+		return
+	}
+	rules := []Rule{{Subnet: subnet, Timeout: 5 * time.Second}}
+
+	l := NewListener(make(chanListener), 0,
+		WithTimeout(3*time.Second),
+		WithFilter(rules),
+		WithPreamble([]byte("secret")),
+	)
+	assert.Equal(t, 3*time.Second, l.t)
+	assert.Equal(t, rules, l.Filter())
+	assert.Equal(t, []byte("secret"), l.preamble)
+
+	l = NewListener(make(chanListener), time.Second)
+	assert.Equal(t, time.Second, l.t)
+	assert.Empty(t, l.Filter())
+	assert.Nil(t, l.preamble)
+}
+
+func TestListener_Policy(t *testing.T) {
+	hdr := "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	accept := func(t *testing.T, p Policy, sends ...string) net.Conn {
+		nl := make(chanListener, len(sends))
+		l := NewListener(nl, time.Second, WithPolicy(p))
+		for _, send := range sends {
+			src, dst := net.Pipe()
+			go func(send string) {
+				io.WriteString(src, send)
+				src.Close()
+			}(send)
+			nl <- dst
+		}
+		c, err := l.Accept()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return c
+	}
+
+	t.Run("require", func(t *testing.T) {
+		c := accept(t, PolicyRequire, "hello")
+		defer c.Close()
+		_, err := c.(*Conn).ProxyHeader()
+		assert.Error(t, err)
+	})
+	t.Run("optional-missing", func(t *testing.T) {
+		c := accept(t, PolicyOptional, "hello")
+		defer c.Close()
+		h, err := c.(*Conn).ProxyHeader()
+		assert.NoError(t, err)
+		assert.Nil(t, h)
+		assert.Equal(t, "pipe", c.RemoteAddr().String())
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(c, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+	})
+	t.Run("optional-present", func(t *testing.T) {
+		c := accept(t, PolicyOptional, hdr)
+		defer c.Close()
+		h, err := c.(*Conn).ProxyHeader()
+		assert.NoError(t, err)
+		assert.NotNil(t, h)
+		assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	})
+	t.Run("reject", func(t *testing.T) {
+		c := accept(t, PolicyReject, "hello", hdr)
+		defer c.Close()
+		assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	})
+}
+
+func TestListener_OnError_OnHeader(t *testing.T) {
+	nl := make(chanListener, 2)
+	l := NewListener(nl, time.Second)
+
+	var gotErr error
+	var gotHdr Header
+	l.OnError(func(c net.Conn, err error) {
+		assert.Equal(t, "pipe", c.RemoteAddr().String())
+		gotErr = err
+	})
+	l.OnHeader(func(c net.Conn, h Header) { gotHdr = h })
+
+	for _, send := range []string{"hello\r\n", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"} {
+		src, dst := net.Pipe()
+		go func(send string) {
+			io.WriteString(src, send)
+			src.Close()
+		}(send)
+		nl <- dst
+	}
+
+	c, err := l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	c.Read(make([]byte, 1))
+	assert.IsType(t, &InvalidHeaderErr{}, gotErr)
+	assert.Nil(t, gotHdr)
+
+	c, err = l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	if assert.NotNil(t, gotHdr) {
+		assert.Equal(t, "192.168.0.1:1234", gotHdr.SrcAddr().String())
+	}
+}
+
+func TestListener_Eager(t *testing.T) {
+	nl := make(chanListener, 2)
+	l := NewListener(nl, time.Second, WithParseMode(ParseEager))
+	errCh := make(chan error, 1)
+	l.OnError(func(c net.Conn, err error) { errCh <- err })
+
+	for _, send := range []string{"hello\r\n", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"} {
+		src, dst := net.Pipe()
+		go func(send string) {
+			io.WriteString(src, send)
+			src.Close()
+		}(send)
+		nl <- dst
+	}
+
+	// the invalid header is skipped and reported only to OnError
+	c, err := l.Accept()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+		c.Close()
+	}
+	select {
+	case err := <-errCh:
+		assert.IsType(t, &InvalidHeaderErr{}, err)
+	case <-time.After(time.Second):
+		t.Error("OnError not called")
+	}
+}
+
+func TestListener_Validators(t *testing.T) {
+	nl := make(chanListener, 3)
+	l := NewListener(nl, time.Second, WithPolicy(PolicyReject), WithValidators(ExpectDestPort(443)))
+	errCh := make(chan error, 1)
+	l.OnError(func(c net.Conn, err error) { errCh <- err })
+
+	for _, send := range []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.2 1234 80\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.2 1234 443\r\n",
+	} {
+		src, dst := net.Pipe()
+		go func(send string) {
+			io.WriteString(src, send)
+			src.Close()
+		}(send)
+		nl <- dst
+	}
+
+	// the rejected header is skipped and reported only to OnError
+	c, err := l.Accept()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "192.168.0.2:443", c.LocalAddr().String())
+		c.Close()
+	}
+	select {
+	case err := <-errCh:
+		assert.IsType(t, &InvalidHeaderErr{}, err)
+		assert.Contains(t, err.Error(), "expected 443")
+	case <-time.After(time.Second):
+		t.Error("OnError not called")
+	}
+
+	// lazily parsed connections return the error when read
+	l.SetPolicy(PolicyRequire)
+	src, dst := net.Pipe()
+	go func() {
+		io.WriteString(src, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 80\r\nhello")
+		src.Close()
+	}()
+	nl <- dst
+	c, err = l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	_, err = c.Read(make([]byte, 5))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+	h, _ := c.(*Conn).ProxyHeader()
+	assert.Nil(t, h)
+}
+
+func TestListener_Close(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// the default timeout outlasts the test, so only Close can stop reading the idle client's header
+	l := NewListener(nl, 0, WithParseMode(ParseEager))
+
+	idle, err := net.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer idle.Close()
+
+	c, err := net.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	io.WriteString(c, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+
+	// start the accept loop, leaving c waiting to be returned
+	go func() {
+		ac, err := l.Accept()
+		if err == nil {
+			ac.Close()
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, l.Close())
+
+	// queued and pending connections are closed, not returned
+	_, err = l.Accept()
+	assert.Error(t, err)
+	for _, conn := range []net.Conn{idle, c} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestListener_Backpressure(t *testing.T) {
+	nl := make(chanListener, maxPendingConns+10)
+	defer close(nl)
+	l := NewListener(nl, 0, WithParseMode(ParseEager))
+
+	var clients []net.Conn
+	for i := 0; i < cap(nl); i++ {
+		src, dst := net.Pipe()
+		clients = append(clients, src)
+		nl <- dst
+	}
+
+	// nothing sends a header, so the loop stops once every slot is taken
+	go l.Accept()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 10, len(nl), "connections left unaccepted")
+
+	l.Close()
+	for _, c := range clients[:maxPendingConns] {
+		_, err := c.Write([]byte("P"))
+		assert.Error(t, err, "pending connection closed")
+	}
+}
+
+func TestListener_LazySync(t *testing.T) {
+	nl := make(chanListener, 1)
+	l := NewListener(nl, time.Second)
+	_, dst := net.Pipe()
+	nl <- dst
+	c, err := l.Accept()
+	if assert.NoError(t, err) {
+		c.Close()
+	}
+	assert.False(t, l.async, "no accept loop without PolicyReject or ParseEager")
+}
+
+func TestListener_Eager_SlowClient(t *testing.T) {
+	for _, p := range []Policy{PolicyRequire, PolicyReject} {
+		mode := ParseLazy
+		if p == PolicyRequire {
+			mode = ParseEager
+		}
+		nl := make(chanListener, 2)
+		// default timeout, an idle client would block until it expires if read during Accept
+		l := NewListener(nl, 0, WithParseMode(mode), WithPolicy(p))
+
+		idle, dst := net.Pipe()
+		defer idle.Close()
+		nl <- dst
+
+		src, dst := net.Pipe()
+		go func() {
+			io.WriteString(src, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+			src.Close()
+		}()
+		nl <- dst
+
+		type result struct {
+			c   net.Conn
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			c, err := l.Accept()
+			ch <- result{c, err}
+		}()
+		select {
+		case res := <-ch:
+			if assert.NoError(t, res.err) {
+				assert.Equal(t, "192.168.0.1:1234", res.c.RemoteAddr().String())
+				res.c.Close()
+			}
+		case <-time.After(time.Second):
+			t.Errorf("policy %d: Accept blocked by idle client", p)
+		}
+		close(nl)
+	}
+}
+
+func TestListener_EagerTimeout(t *testing.T) {
+	for _, p := range []Policy{PolicyRequire, PolicyReject} {
+		nl := make(chanListener, 1)
+		l := NewListener(nl, 0, WithParseMode(ParseEager), WithPolicy(p))
+		l.eagerT = 50 * time.Millisecond
+		errCh := make(chan error, 1)
+		l.OnError(func(c net.Conn, err error) { errCh <- err })
+
+		idle, dst := net.Pipe()
+		defer idle.Close()
+		nl <- dst
+		go l.Accept()
+
+		select {
+		case err := <-errCh:
+			assert.True(t, errors.Is(err, ErrHeaderTimeout), "policy %d: %v", p, err)
+		case <-time.After(time.Second):
+			t.Errorf("policy %d: idle client held without a timeout", p)
+		}
+		l.Close()
+	}
+}
+
+func TestListener_Eager_HTTP(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer nl.Close()
+	l := NewListener(nl, time.Second, WithParseMode(ParseEager))
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.RemoteAddr)
+	})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	defer srv.Close()
+
+	// a client sending garbage must not stop the server
+	c, err := net.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	io.WriteString(c, "GARBAGE\r\n")
+	ioutil.ReadAll(c)
+	c.Close()
+
+	c, err = net.Dial("tcp", nl.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	io.WriteString(c, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nGET / HTTP/1.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "192.168.0.1:1234", string(body))
+	}
+
+	select {
+	case err := <-served:
+		t.Fatal("server stopped:", err)
+	default:
+	}
+}
+
+func TestListener_SetParseMode(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	check := func(m ParseMode, eager bool) {
+		nl := make(chanListener, 1)
+		l := NewListener(nl, time.Second, WithParseMode(m))
+		src, dst := net.Pipe()
+		defer src.Close()
+		wrote := make(chan time.Time, 1)
+		go func() {
+			// slow writer, the header is only sent after a delay
+			time.Sleep(delay)
+			wrote <- time.Now()
+			io.WriteString(src, "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+		}()
+		nl <- dst
+		c, err := l.Accept()
+		accepted := time.Now()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		if eager {
+			assert.False(t, accepted.Before(<-wrote), "Accept returned before header was sent")
+		} else {
+			select {
+			case <-wrote:
+				t.Error("Accept waited for header")
+			default:
+			}
+		}
+		assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	}
+
+	check(ParseLazy, false)
+	check(ParseEager, true)
+
+	l := NewListener(make(chanListener), time.Second, WithEager(true))
+	assert.Equal(t, ParseEager, l.mode)
+	l.SetEager(false)
+	assert.Equal(t, ParseLazy, l.mode)
+}
+
+func TestListener_SetFilter_Dedup(t *testing.T) {
+	_, n, _ := net.ParseCIDR("192.168.0.0/24")
+	_, other, _ := net.ParseCIDR("192.168.1.0/24")
+
+	l := NewListener(make(chanListener), 0)
+	l.SetFilter([]Rule{
+		{Subnet: n, Timeout: 0},
+		{Subnet: other, Timeout: time.Second},
+		{Subnet: n, Timeout: 5 * time.Second},
+		{Subnet: n, Timeout: 2 * time.Second},
+	})
+
+	assert.Equal(t, []Rule{
+		{Subnet: n, Timeout: 2 * time.Second},
+		{Subnet: other, Timeout: time.Second},
+	}, l.Filter())
+}
+
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestListener_FilterMappedIPv4(t *testing.T) {
+	_, n, _ := net.ParseCIDR("1.2.3.0/24")
+	nl := make(chanListener, 1)
+	l := NewListener(nl, 0, WithFilter([]Rule{{Subnet: n}}))
+
+	check := func(ip net.IP, match bool) {
+		t.Helper()
+		_, dst := net.Pipe()
+		defer dst.Close()
+		nl <- remoteAddrConn{Conn: dst, remote: &net.TCPAddr{IP: ip, Port: 1234}}
+
+		c, err := l.Accept()
+		if !assert.NoError(t, err, ip.String()) {
+			return
+		}
+		_, isConn := c.(*Conn)
+		assert.Equal(t, match, isConn, ip.String())
+	}
+
+	// net.ParseIP always returns the 16-byte (IPv4-mapped) form
+	check(net.ParseIP("1.2.3.4").To4(), true)
+	check(net.ParseIP("::ffff:1.2.3.4"), true)
+	check(net.ParseIP("1.2.4.4").To4(), false)
+	check(net.ParseIP("::ffff:1.2.4.4"), false)
+
+	// 16-byte subnet IP with a 4-byte mask
+	l.SetFilter([]Rule{{Subnet: &net.IPNet{IP: net.ParseIP("1.2.3.0"), Mask: net.CIDRMask(24, 32)}}})
+	assert.Equal(t, n.IP, l.Filter()[0].Subnet.IP, "normalized subnet IP")
+	check(net.ParseIP("1.2.3.4").To4(), true)
+	check(net.ParseIP("::ffff:1.2.3.4"), true)
+	check(net.ParseIP("1.2.4.4").To4(), false)
+}
+
+func TestListener_MaxHeaders(t *testing.T) {
+	accept := func(n int, send string) *Conn {
+		nl := make(chanListener, 1)
+		l := NewListener(nl, time.Second, WithMaxHeaders(n))
+		src, dst := net.Pipe()
+		go func() {
+			io.WriteString(src, send)
+			src.Close()
+		}()
+		nl <- dst
+		c, err := l.Accept()
+		assert.NoError(t, err)
+		return c.(*Conn)
+	}
+
+	const (
+		outer = "PROXY TCP4 10.0.0.1 10.0.0.2 1000 2000\r\n"
+		inner = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	)
+
+	c := accept(2, outer+inner+"hello")
+	assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	assert.Equal(t, "192.168.0.2:5678", c.LocalAddr().String())
+	hdrs, err := c.ProxyHeaders()
+	assert.NoError(t, err)
+	assert.Len(t, hdrs, 2)
+	data, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// innermost header without addresses is skipped
+	c = accept(2, outer+"PROXY UNKNOWN\r\nhello")
+	assert.Equal(t, "10.0.0.1:1000", c.RemoteAddr().String())
+	h, err := c.ProxyHeader()
+	assert.NoError(t, err)
+	assert.Nil(t, h.SrcAddr())
+
+	// limit reached, remaining header is application data
+	c = accept(1, outer+inner)
+	assert.Equal(t, "10.0.0.1:1000", c.RemoteAddr().String())
+	data, err = ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, inner, string(data))
+}
+
+func TestListener_MaxV2Size(t *testing.T) {
+	nl := make(chanListener, 1)
+	l := NewListener(nl, time.Second, WithMaxV2Size(64))
+
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2").To4(), Port: 5678},
+		TLVs:    []TLV{{Type: PP2TypeNOOP, Value: make([]byte, 100)}},
+	}
+	data, err := h.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	src, dst := net.Pipe()
+	defer src.Close()
+	go src.Write(data)
+	nl <- dst
+	c, err := l.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	_, err = c.(*Conn).ProxyHeader()
+	assert.True(t, errors.Is(err, ErrHeaderTooLong), "expected ErrHeaderTooLong, got %v", err)
+}
+
+func TestListener_RuleOptional(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/24")
+	_, mon, _ := net.ParseCIDR("10.0.1.0/24")
+	nl := make(chanListener, 1)
+	l := NewListener(nl, time.Second, WithFilter([]Rule{
+		{Subnet: lb},
+		{Subnet: mon, Optional: true},
+	}))
+
+	accept := func(ip, send string) net.Conn {
+		t.Helper()
+		src, dst := net.Pipe()
+		go func() {
+			io.WriteString(src, send)
+			src.Close()
+		}()
+		nl <- remoteAddrConn{Conn: dst, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+		c, err := l.Accept()
+		assert.NoError(t, err, ip)
+		return c
+	}
+	read := func(c net.Conn) (string, error) {
+		data, err := ioutil.ReadAll(c)
+		return string(data), err
+	}
+	const hdr = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+
+	// required
+	c := accept("10.0.0.1", hdr+"hello")
+	assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	data, err := read(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", data)
+
+	c = accept("10.0.0.1", "hello")
+	_, err = read(c)
+	assert.IsType(t, &InvalidHeaderErr{}, err, "required")
+
+	// optional
+	c = accept("10.0.1.1", hdr+"hello")
+	assert.Equal(t, "192.168.0.1:1234", c.RemoteAddr().String())
+	data, err = read(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", data)
+
+	c = accept("10.0.1.1", "hello")
+	assert.Equal(t, "10.0.1.1:1234", c.RemoteAddr().String())
+	data, err = read(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", data)
+
+	// unmatched, header is application data
+	c = accept("10.0.2.1", hdr+"hello")
+	assert.Equal(t, "10.0.2.1:1234", c.RemoteAddr().String())
+	data, err = read(c)
+	assert.NoError(t, err)
+	assert.Equal(t, hdr+"hello", data)
+}
+
+func TestListener_SetFilter_DedupOptional(t *testing.T) {
+	_, n, _ := net.ParseCIDR("192.168.0.0/24")
+	_, other, _ := net.ParseCIDR("192.168.1.0/24")
+
+	l := NewListener(make(chanListener), 0)
+	l.SetFilter([]Rule{
+		{Subnet: n, Timeout: time.Second, Optional: true},
+		{Subnet: n, Timeout: 2 * time.Second},
+		{Subnet: other, Optional: true},
+		{Subnet: other, Timeout: time.Second, Optional: true},
+	})
+
+	assert.Equal(t, []Rule{
+		{Subnet: n, Timeout: time.Second},
+		{Subnet: other, Timeout: time.Second, Optional: true},
+	}, l.Filter())
+}
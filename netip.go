@@ -0,0 +1,87 @@
+//go:build go1.18
+// +build go1.18
+
+package proxyprotocol
+
+import (
+	"net"
+	"net/netip"
+)
+
+// SrcAddrPort returns the source address of h as a netip.AddrPort.
+//
+// IPv4-mapped IPv6 addresses are unmapped to IPv4; use SrcAddrPortRaw to preserve them.
+// False is returned if the source is not a TCP or UDP address.
+func SrcAddrPort(h Header) (netip.AddrPort, bool) {
+	if h == nil {
+		return netip.AddrPort{}, false
+	}
+	return headerAddrPort(h, h.SrcAddr(), false)
+}
+
+// DestAddrPort returns the destination address of h as a netip.AddrPort.
+//
+// IPv4-mapped IPv6 addresses are unmapped to IPv4; use DestAddrPortRaw to preserve them.
+// False is returned if the destination is not a TCP or UDP address.
+func DestAddrPort(h Header) (netip.AddrPort, bool) {
+	if h == nil {
+		return netip.AddrPort{}, false
+	}
+	return headerAddrPort(h, h.DestAddr(), false)
+}
+
+// SrcAddrPortRaw is like SrcAddrPort but keeps the address in the form it was
+// sent on the wire, preserving IPv4-mapped IPv6 addresses (e.g. ::ffff:192.168.0.1).
+func SrcAddrPortRaw(h Header) (netip.AddrPort, bool) {
+	if h == nil {
+		return netip.AddrPort{}, false
+	}
+	return headerAddrPort(h, h.SrcAddr(), true)
+}
+
+// DestAddrPortRaw is like DestAddrPort but keeps the address in the form it was
+// sent on the wire, preserving IPv4-mapped IPv6 addresses (e.g. ::ffff:192.168.0.1).
+func DestAddrPortRaw(h Header) (netip.AddrPort, bool) {
+	if h == nil {
+		return netip.AddrPort{}, false
+	}
+	return headerAddrPort(h, h.DestAddr(), true)
+}
+
+func headerAddrPort(h Header, a net.Addr, raw bool) (netip.AddrPort, bool) {
+	var port int
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		port = a.Port
+	case *net.UDPAddr:
+		port = a.Port
+	default:
+		return netip.AddrPort{}, false
+	}
+	addr, ok := netip.AddrFromSlice(addrIP(a))
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+
+	if !raw {
+		return netip.AddrPortFrom(addr.Unmap(), uint16(port)), true
+	}
+
+	// V1 addresses are always parsed into the 16-byte form, so the
+	// wire format is determined by the declared protocol instead.
+	var fam string
+	switch h := h.(type) {
+	case HeaderV1:
+		fam = h.protoFam()
+	case *HeaderV1:
+		fam = h.protoFam()
+	}
+	switch fam {
+	case "TCP4":
+		addr = addr.Unmap()
+	case "TCP6":
+		addr = netip.AddrFrom16(addr.As16())
+	}
+
+	return netip.AddrPortFrom(addr, uint16(port)), true
+}
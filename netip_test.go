@@ -0,0 +1,66 @@
+//go:build go1.18
+// +build go1.18
+
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSrcAddrPort(t *testing.T) {
+	check := func(name string, h Header, expSrc, expSrcRaw, expDst, expDstRaw string) {
+		t.Helper()
+		src, ok := SrcAddrPort(h)
+		assert.True(t, ok, name)
+		assert.Equal(t, expSrc, src.String(), name+" SrcAddrPort")
+		src, ok = SrcAddrPortRaw(h)
+		assert.True(t, ok, name)
+		assert.Equal(t, expSrcRaw, src.String(), name+" SrcAddrPortRaw")
+		dst, ok := DestAddrPort(h)
+		assert.True(t, ok, name)
+		assert.Equal(t, expDst, dst.String(), name+" DestAddrPort")
+		dst, ok = DestAddrPortRaw(h)
+		assert.True(t, ok, name)
+		assert.Equal(t, expDstRaw, dst.String(), name+" DestAddrPortRaw")
+	}
+
+	v1, err := Parse(bufio.NewReader(strings.NewReader("PROXY TCP6 ::ffff:192.168.0.1 2001:db8::1 1234 5678\r\n")))
+	assert.NoError(t, err)
+	check("v1-tcp6-mapped", v1,
+		"192.168.0.1:1234", "[::ffff:192.168.0.1]:1234",
+		"[2001:db8::1]:5678", "[2001:db8::1]:5678",
+	)
+
+	v1, err = Parse(bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")))
+	assert.NoError(t, err)
+	check("v1-tcp4", v1,
+		"192.168.0.1:1234", "192.168.0.1:1234",
+		"192.168.0.2:5678", "192.168.0.2:5678",
+	)
+
+	// INET6 header carrying an IPv4-mapped source
+	data := append([]byte{}, sigV2...)
+	data = append(data, 0x21, 0x21, 0, 36)
+	data = append(data, net.ParseIP("::ffff:192.168.0.1")...)
+	data = append(data, net.ParseIP("2001:db8::1")...)
+	data = append(data, 0x04, 0xd2, 0x16, 0x2e)
+	v2, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	check("v2-tcp6-mapped", v2,
+		"192.168.0.1:1234", "[::ffff:192.168.0.1]:1234",
+		"[2001:db8::1]:5678", "[2001:db8::1]:5678",
+	)
+
+	_, ok := SrcAddrPort(&HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "foo"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: "bar"},
+	})
+	assert.False(t, ok, "unix")
+}
@@ -0,0 +1,123 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+)
+
+// PacketConn wraps a net.PacketConn where the first datagram received begins with a V2 PROXY header.
+//
+// The header is expected once, on the first valid datagram, as with a connected UDP socket
+// relaying a single client. Datagrams from the same peer that sent the header are reported
+// as coming from the header's source address, and writes to that address are sent to the peer.
+// Datagrams from any other address are passed through unchanged.
+type PacketConn struct {
+	net.PacketConn
+
+	mx   sync.Mutex
+	hdr  Header
+	peer net.Addr
+
+	// rmx serializes reads until the header is received, so only one ReadFrom reads a datagram
+	// expected to contain it, into buf.
+	rmx sync.Mutex
+	buf []byte
+}
+
+// WrapPacketConn will wrap an existing net.PacketConn. The header is read from the
+// first valid datagram received by ReadFrom.
+func WrapPacketConn(pc net.PacketConn) *PacketConn {
+	return &PacketConn{PacketConn: pc}
+}
+
+// ProxyHeader will return the PROXY header received on the first valid datagram, or
+// nil if one has not been received yet. The error is always nil.
+func (c *PacketConn) ProxyHeader() (Header, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.hdr, nil
+}
+
+// ReadFrom implements net.PacketConn, stripping the PROXY header from the first datagram.
+//
+// Until a valid header has been received, each datagram that does not begin with one is discarded
+// and an InvalidHeaderErr is returned for it, so a stray datagram does not prevent a later valid one from
+// being used. Errors from the underlying net.PacketConn (e.g. a read deadline) are returned as-is.
+// Only one ReadFrom reads a datagram at a time until the header is received; concurrent calls wait.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mx.Lock()
+	hdr, peer := c.hdr, c.peer
+	c.mx.Unlock()
+	if hdr == nil {
+		c.rmx.Lock()
+		c.mx.Lock()
+		hdr, peer = c.hdr, c.peer
+		c.mx.Unlock()
+		if hdr == nil {
+			defer c.rmx.Unlock()
+			return c.readHeader(p)
+		}
+		c.rmx.Unlock()
+	}
+
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	return n, proxySrcAddr(hdr, peer, addr), err
+}
+
+// WriteTo implements net.PacketConn. Datagrams addressed to the header's source address
+// are sent to the peer that sent the header.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mx.Lock()
+	hdr, peer := c.hdr, c.peer
+	c.mx.Unlock()
+
+	if hdr != nil && hdr.SrcAddr() != nil && addrEqual(addr, hdr.SrcAddr()) {
+		addr = peer
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// readHeader reads a datagram expected to begin with a header, copying the remaining payload to p.
+//
+// c.rmx must be held.
+func (c *PacketConn) readHeader(p []byte) (int, net.Addr, error) {
+	if c.buf == nil {
+		c.buf = make([]byte, 0xffff)
+	}
+	n, addr, err := c.PacketConn.ReadFrom(c.buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	buf := c.buf[:n]
+	if !bytes.HasPrefix(buf, sigV2) {
+		return 0, nil, &InvalidHeaderErr{Read: append([]byte(nil), buf...), error: ErrNoSignature}
+	}
+
+	br := bytes.NewReader(buf)
+	r := bufio.NewReader(br)
+	hdr, err := parseV2(r, ParseOptions{})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c.mx.Lock()
+	c.hdr = hdr
+	c.peer = addr
+	c.mx.Unlock()
+
+	payload := buf[n-r.Buffered()-br.Len():]
+	n = copy(p, payload)
+	// the buffer is not needed once the header is received
+	c.buf = nil
+	return n, proxySrcAddr(hdr, addr, addr), nil
+}
+
+// proxySrcAddr returns the source address of hdr if addr is the peer that sent it.
+func proxySrcAddr(hdr Header, peer, addr net.Addr) net.Addr {
+	if hdr == nil || hdr.SrcAddr() == nil || !addrEqual(addr, peer) {
+		return addr
+	}
+	return hdr.SrcAddr()
+}
@@ -0,0 +1,182 @@
+package proxyprotocol
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 1234}
+	hdr := HeaderV2{
+		Command: CmdProxy,
+		Src:     src,
+		Dest:    &net.UDPAddr{IP: net.ParseIP("192.168.0.2").To4(), Port: 53},
+	}
+	data, err := hdr.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pc := WrapPacketConn(server)
+	client.Write(append(data, "hello"...))
+	client.Write([]byte("world"))
+
+	buf := make([]byte, 100)
+	n, addr, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, src.String(), addr.String())
+
+	n, addr, err = pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+	assert.Equal(t, src.String(), addr.String())
+
+	h, err := pc.ProxyHeader()
+	assert.NoError(t, err)
+	assert.True(t, Equal(&hdr, h))
+
+	_, err = pc.WriteTo([]byte("reply"), addr)
+	assert.NoError(t, err)
+	n, err = client.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", string(buf[:n]))
+}
+
+func TestPacketConn_Invalid(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	pc := WrapPacketConn(server)
+	client.Write([]byte("hello"))
+
+	buf := make([]byte, 100)
+	_, _, err = pc.ReadFrom(buf)
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+	assert.True(t, errors.Is(err, ErrNoSignature))
+	h, _ := pc.ProxyHeader()
+	assert.Nil(t, h)
+
+	// a read timeout is not sticky either
+	pc.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = pc.ReadFrom(buf)
+	if ne, ok := err.(net.Error); assert.True(t, ok, "net.Error") {
+		assert.True(t, ne.Timeout())
+	}
+	pc.SetReadDeadline(time.Time{})
+
+	// a valid header is still accepted afterwards
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 1234},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("192.168.0.2").To4(), Port: 53},
+	}.MarshalBinary()
+	assert.NoError(t, err)
+	client.Write(append(data, "hello"...))
+	n, addr, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, "192.168.0.1:1234", addr.String())
+}
+
+func TestPacketConn_BlockedRead(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	pc := WrapPacketConn(server)
+	go pc.ReadFrom(make([]byte, 100))
+	time.Sleep(10 * time.Millisecond)
+
+	// a pending ReadFrom must not block other methods
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pc.ProxyHeader()
+		pc.WriteTo([]byte("hello"), server.LocalAddr())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("blocked by pending ReadFrom")
+	}
+}
+
+func TestPacketConn_ConcurrentRead(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	pc := WrapPacketConn(server)
+	type result struct {
+		data string
+		addr net.Addr
+		err  error
+	}
+	ch := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			buf := make([]byte, 100)
+			n, addr, err := pc.ReadFrom(buf)
+			ch <- result{string(buf[:n]), addr, err}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// only one pending ReadFrom may expect the header, the other must read the next datagram as-is
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 1234},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("192.168.0.2").To4(), Port: 53},
+	}.MarshalBinary()
+	assert.NoError(t, err)
+	client.Write(append(data, "hello"...))
+	time.Sleep(10 * time.Millisecond)
+	client.Write([]byte("world"))
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case res := <-ch:
+			if assert.NoError(t, res.err) {
+				assert.Equal(t, "192.168.0.1:1234", res.addr.String())
+			}
+			got = append(got, res.data)
+		case <-time.After(time.Second):
+			t.Fatal("ReadFrom blocked")
+		}
+	}
+	assert.ElementsMatch(t, []string{"hello", "world"}, got)
+}
@@ -2,34 +2,377 @@ package proxyprotocol
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"time"
 )
 
 var (
 	sigV1 = []byte("PROXY %s %s %s %d %d\r\n")
 	sigV2 = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	// sigV1Prefix is the fixed start of every V1 header.
+	sigV1Prefix = []byte("PROXY ")
 )
 
+// ErrNoSignature is returned within an InvalidHeaderErr when the data does not begin with a V1 or V2
+// signature, meaning it is not a PROXY header at all, as opposed to a PROXY header that is malformed
+// or truncated. It can be checked with errors.Is, e.g. to fall back to another protocol (see also Detect).
+var ErrNoSignature = errors.New("invalid signature")
+
+// MaxHeaderSize returns the largest number of bytes a single PROXY header may occupy.
+//
+// This is a V2 header with the maximum length; V1 headers are at most 107 bytes. To account for
+// a configured limit, use ParseOptions.MaxHeaderSize or Listener.MaxHeaderSize.
+func MaxHeaderSize() int { return 16 + 0xffff }
+
+// MaxHeaderSize returns the largest number of bytes a single PROXY header parsed with o may occupy,
+// taking MaxV2Size into account. V1 headers are not affected by MaxV2Size, so the result is never
+// less than the maximum V1 header size of 107 bytes.
+func (o ParseOptions) MaxHeaderSize() int {
+	if o.MaxV2Size <= 0 || o.MaxV2Size >= MaxHeaderSize() {
+		return MaxHeaderSize()
+	}
+	if o.MaxV2Size < maxV1Len {
+		return maxV1Len
+	}
+	return o.MaxV2Size
+}
+
 // InvalidHeaderErr contains the parsing error as well as all data read from the reader.
 type InvalidHeaderErr struct {
 	error
 	Read []byte
+
+	// Version is the header version being parsed when the error occurred (1 or 2), as determined by
+	// the first byte read, or 0 if the data did not begin with either signature.
+	Version int
+}
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As.
+func (e *InvalidHeaderErr) Unwrap() error { return e.error }
+
+// ParseOptions configures optional parsing behavior for ParseWithOptions.
+type ParseOptions struct {
+	// IncludeLocalAddrs will decode any address data sent with a V2 LOCAL command into
+	// HeaderV2.RawSrc and RawDest. SrcAddr and DestAddr remain nil.
+	IncludeLocalAddrs bool
+
+	// MaxV2Size limits the total size of a V2 header, including the 16-byte preamble. Larger
+	// headers are rejected with ErrHeaderTooLong before the remainder is read. Zero means no limit
+	// beyond the protocol maximum (see MaxHeaderSize).
+	MaxV2Size int
+
+	// StrictV1 rejects V1 headers that do not follow the specification exactly, such as ports with
+	// leading zeros, extra whitespace, or addresses not matching the TCP4 or TCP6 protocol (e.g. an
+	// IPv4 address with TCP6). By default, such headers are accepted if they can be parsed.
+	StrictV1 bool
 }
 
 // Parse will parse detect and return a V1 or V2 header, otherwise InvalidHeaderErr is returned.
+//
+// Only the header itself is consumed, so on success r is positioned at the first byte following
+// the header and can continue to be used to read application data.
+//
+// If a V2 header contains a PP2TypeCRC32C TLV, the checksum is verified and
+// ErrCRCMismatch is returned within an InvalidHeaderErr if it does not match.
+//
+// The reader underlying r should block until data is available. A reader that returns (0, nil)
+// is retried by bufio.Reader, but after too many consecutive empty reads io.ErrNoProgress is returned.
 func Parse(r *bufio.Reader) (Header, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// ParseWithOptions is like Parse, with optional behavior configured by opts.
+func ParseWithOptions(r *bufio.Reader, opts ParseOptions) (Header, error) {
+	return parseInto(r, opts, nil, nil)
+}
+
+// ParseInto is like Parse, but fills in v1 or v2 (depending on the version received) instead of
+// allocating a new header, and returns it as a Header. If the matching struct is nil, a new one is allocated.
+//
+// All fields of the matching struct are overwritten on success, replacing (not modifying) any previous
+// addresses or TLVs, so the returned header remains valid until v1 or v2 is passed to ParseInto again.
+// The contents are unspecified if an error is returned.
+//
+// If r is not a *bufio.Reader it is wrapped in one, so data following the header may be
+// consumed from r; pass a *bufio.Reader to continue reading from it afterwards.
+func ParseInto(r io.Reader, v1 *HeaderV1, v2 *HeaderV2) (Header, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return parseInto(br, ParseOptions{}, v1, v2)
+}
+
+// parseInto implements ParseInto with opts.
+func parseInto(r *bufio.Reader, opts ParseOptions, v1 *HeaderV1, v2 *HeaderV2) (Header, error) {
 	b, err := r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
 	r.UnreadByte()
 
-	switch b {
-	case sigV1[0]:
-		return parseV1(r)
-	case sigV2[0]:
-		return parseV2(r)
+	// check the complete signature, so data that isn't a PROXY header is left unread
+	switch {
+	case b == sigV1[0] && hasSignature(r, sigV1Prefix):
+		if v1 == nil {
+			v1 = new(HeaderV1)
+		}
+		err = parseV1Into(r, opts, v1)
+		if err != nil {
+			return nil, err
+		}
+		return v1, nil
+	case b == sigV2[0] && hasSignature(r, sigV2):
+		if v2 == nil {
+			v2 = new(HeaderV2)
+		}
+		_, err = parseV2Into(r, opts, false, v2)
+		if err != nil {
+			return nil, err
+		}
+		return v2, nil
+	}
+
+	return nil, &InvalidHeaderErr{error: ErrNoSignature}
+}
+
+// hasSignature reports whether r begins with sig, without consuming anything. Data ending early (or a read
+// error) after a partial match counts as a match, leaving the error to be reported by the header parser.
+func hasSignature(r *bufio.Reader, sig []byte) bool {
+	b, _ := r.Peek(len(sig))
+	return bytes.HasPrefix(sig, b)
+}
+
+// ParseLimited is like Parse, but reads at most maxBytes from r. If the header does not fit within
+// maxBytes, an InvalidHeaderErr containing ErrHeaderTooLong is returned.
+//
+// A V2 header declaring a larger length is rejected as soon as its length is read (see
+// ParseOptions.MaxV2Size), rather than after waiting for the rest of the header.
+//
+// Reads from r are buffered, so data following the header (up to maxBytes in total) may be consumed from r.
+func ParseLimited(r io.Reader, maxBytes int) (Header, error) {
+	lr := &io.LimitedReader{R: r, N: int64(maxBytes)}
+	h, err := ParseWithOptions(bufio.NewReader(lr), ParseOptions{MaxV2Size: maxBytes})
+	if ihe, ok := err.(*InvalidHeaderErr); ok && lr.N <= 0 && (ihe.error == io.EOF || ihe.error == io.ErrUnexpectedEOF) {
+		ihe.error = ErrHeaderTooLong
 	}
+	if err == io.EOF && lr.N <= 0 {
+		// limit of zero
+		err = &InvalidHeaderErr{error: ErrHeaderTooLong}
+	}
+	return h, err
+}
+
+// ParseBytes is like Parse, but reads the header from the beginning of b, returning the number of bytes
+// it occupies, so that any application data in a captured buffer begins at b[n:].
+//
+// If b ends before the end of the header, an InvalidHeaderErr is returned (or io.EOF if b is empty).
+func ParseBytes(b []byte) (Header, int, error) {
+	r := bytes.NewReader(b)
+	br := bufio.NewReader(r)
+	h, err := Parse(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, len(b) - r.Len() - br.Buffered(), nil
+}
+
+// ParseUnbuffered is like Parse, but reads from r without buffering, so nothing past the end of
+// the header is consumed and r (e.g. a net.Conn) can continue to be used directly afterwards.
+//
+// Data is read from r one byte at a time, which is slow for unbuffered readers like a net.Conn,
+// so this should only be used when wrapping r with a *bufio.Reader (see Parse) is not an option.
+func ParseUnbuffered(r io.Reader) (Header, error) {
+	return Parse(bufio.NewReader(oneByteReader{r}))
+}
 
-	return nil, &InvalidHeaderErr{error: errors.New("invalid signature")}
+// oneByteReader limits each Read to a single byte, so a bufio.Reader never buffers more than it needs.
+type oneByteReader struct{ r io.Reader }
+
+func (r oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.r.Read(p)
+}
+
+// ParseRaw is like Parse, but also returns the exact bytes of the header as read from r: the V1 line
+// including the CRLF, or the complete V2 header including any TLVs.
+//
+// If parsing fails with an InvalidHeaderErr, the data read so far is returned (see InvalidHeaderErr.Read).
+//
+// If r is not a *bufio.Reader it is wrapped in one, so data following the header may be
+// consumed from r; pass a *bufio.Reader to continue reading from it afterwards.
+func ParseRaw(r io.Reader) (Header, []byte, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	h, raw, err := parseRaw(br)
+	if ihe, ok := err.(*InvalidHeaderErr); ok {
+		return nil, ihe.Read, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, raw, nil
+}
+
+func parseRaw(r *bufio.Reader) (Header, []byte, error) {
+	v, err := Detect(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch v {
+	case 1:
+		line, err := readV1Line(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := parseV1Line(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		return h, line, nil
+	case 2:
+		return parseV2Raw(r, ParseOptions{})
+	}
+
+	// not a valid header, use Parse for a consistent error
+	_, err = Parse(r)
+	return nil, nil, err
+}
+
+// ParseAll will parse one or more consecutive headers from r, as sent when a connection passes through
+// multiple proxies that each add their own header. Headers are returned in the order received, so the
+// last one is the innermost header, describing the original client connection.
+//
+// The first header is required, as with Parse. Each following header is only read if the data begins
+// with a complete V1 or V2 signature; anything else, including application data that happens to start
+// with the first bytes of a signature, is left unread in r. Checking for another header requires at least
+// one more byte, so ParseAll blocks until the client sends data or closes the connection, and should not
+// be used with protocols where the server speaks first.
+func ParseAll(r *bufio.Reader) ([]Header, error) { return parseChain(r, 0, ParseOptions{}) }
+
+// parseChain implements ParseAll, reading at most max headers if max is positive.
+func parseChain(r *bufio.Reader, max int, opts ParseOptions) ([]Header, error) {
+	h, err := ParseWithOptions(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	hdrs := []Header{h}
+	for max <= 0 || len(hdrs) < max {
+		v, err := Detect(r)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 {
+			break
+		}
+		h, err = ParseWithOptions(r, opts)
+		if err != nil {
+			return nil, err
+		}
+		hdrs = append(hdrs, h)
+	}
+	return hdrs, nil
+}
+
+// Detect will peek at r to determine if a V1 or V2 header follows, returning the version
+// or 0 if the data does not begin with a PROXY signature (including if it ends before a complete
+// signature). No data is consumed from r, so it can be passed to Parse or another protocol's parser
+// (e.g. when multiplexing PROXY and TLS connections on the same port).
+//
+// Only as many bytes as needed are peeked, at most 12, so a short non-PROXY message is detected as
+// soon as it stops matching a signature.
+func Detect(r *bufio.Reader) (int, error) {
+	sig1 := sigV1Prefix
+	for n := 1; n <= len(sigV2); n++ {
+		b, err := r.Peek(n)
+		if err == io.EOF {
+			// stream ended before a complete signature
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v1 := n <= len(sig1) && bytes.Equal(b, sig1[:n])
+		v2 := bytes.Equal(b, sigV2[:n])
+		switch {
+		case v1 && n == len(sig1):
+			return 1, nil
+		case v2 && n == len(sigV2):
+			return 2, nil
+		case !v1 && !v2:
+			return 0, nil
+		}
+	}
+	return 0, nil
+}
+
+// ParseContext is like Parse, but will abort when ctx is done, returning ctx.Err().
+//
+// If r implements SetReadDeadline (e.g. a net.Conn), the read deadline is set from ctx and
+// cleared after parsing. Since a deadline can't be read back, any read deadline already set on r is
+// also cleared, and must be set again afterwards if needed. Otherwise the read is performed in a separate goroutine, which will
+// remain blocked on r after cancellation until r returns.
+//
+// If r is not a *bufio.Reader it is wrapped in one, so data following the header may be
+// consumed from r; pass a *bufio.Reader to continue reading from it afterwards.
+func ParseContext(ctx context.Context, r io.Reader) (Header, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		deadline, hasDeadline := ctx.Deadline()
+		if hasDeadline {
+			d.SetReadDeadline(deadline)
+		}
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				// unblock the pending read
+				d.SetReadDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		h, err := Parse(br)
+		close(done)
+		<-stopped
+		d.SetReadDeadline(time.Time{})
+		if err != nil && hasDeadline && isTimeout(err) && !time.Now().Before(deadline) {
+			// the read deadline can expire just before ctx reports it
+			<-ctx.Done()
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return h, err
+	}
+
+	type result struct {
+		h   Header
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		h, err := Parse(br)
+		ch <- result{h: h, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.h, res.err
+	}
 }
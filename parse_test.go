@@ -3,9 +3,16 @@ package proxyprotocol
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -42,6 +49,8 @@ func TestParse_HeaderV1(t *testing.T) {
 	}
 
 	check("blank", HeaderV1{}, "PROXY UNKNOWN\r\n")
+	check("unknown-addrs", HeaderV1{}, "PROXY UNKNOWN ffff::1 ffff::2 0 0\r\n")
+	check("unknown-max", HeaderV1{}, "PROXY UNKNOWN "+strings.Repeat("x", 107-16)+"\r\n")
 	check("ipv4", HeaderV1{
 		SrcPort:  1234,
 		DestPort: 5678,
@@ -61,11 +70,570 @@ func TestParse_HeaderV1(t *testing.T) {
 	)
 
 	check("ipv6-mapped-ipv4", HeaderV1{
-		SrcPort:  53740,
-		DestPort: 10001,
-		SrcIP:    net.ParseIP("::ffff:192.168.0.1"),
-		DestIP:   net.ParseIP("::ffff:192.168.0.1"),
+		SrcPort:   53740,
+		DestPort:  10001,
+		SrcIP:     net.ParseIP("::ffff:192.168.0.1"),
+		DestIP:    net.ParseIP("::ffff:192.168.0.1"),
+		ForceTCP6: true,
 	},
 		"PROXY TCP6 ::ffff:192.168.0.1 ::ffff:192.168.0.1 53740 10001\r\n",
 	)
 }
+
+type emptyReader struct {
+	r       io.Reader
+	empty   int
+	pending int
+	calls   int
+}
+
+func (r *emptyReader) Read(p []byte) (int, error) {
+	r.calls++
+	if r.pending > 0 {
+		r.pending--
+		return 0, nil
+	}
+	r.pending = r.empty
+	if len(p) > 8 {
+		p = p[:8]
+	}
+	return r.r.Read(p)
+}
+
+func TestParse_HeaderV1_Invalid(t *testing.T) {
+	check := func(name, data string) {
+		t.Helper()
+		_, err := Parse(bufio.NewReader(strings.NewReader(data)))
+		assert.IsType(t, &InvalidHeaderErr{}, err, name)
+	}
+
+	check("too-long", "PROXY UNKNOWN "+strings.Repeat("x", 108-16)+"\r\n")
+	check("unknown-suffix", "PROXY UNKNOWNX\r\n")
+	check("no-crlf", "PROXY UNKNOWN")
+}
+
+func TestParse_HeaderV1_TooLong(t *testing.T) {
+	data := "PROXY " + strings.Repeat("x", 200)
+	_, err := Parse(bufio.NewReader(strings.NewReader(data)))
+	if assert.IsType(t, &InvalidHeaderErr{}, err) {
+		ihe := err.(*InvalidHeaderErr)
+		assert.Equal(t, ErrHeaderTooLong, ihe.error)
+		assert.Len(t, ihe.Read, 107)
+	}
+}
+
+func TestParse_Remainder(t *testing.T) {
+	v2 := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+	}
+	for _, h := range []Header{&HeaderV1{}, v2} {
+		var buf bytes.Buffer
+		h.WriteTo(&buf)
+		buf.WriteString("hello")
+
+		r := bufio.NewReader(&buf)
+		_, err := Parse(r)
+		assert.NoError(t, err)
+
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	}
+}
+
+func TestParse_EmptyReads(t *testing.T) {
+	const hdr = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	r := &emptyReader{r: strings.NewReader(hdr), empty: 3, pending: 3}
+
+	h, err := Parse(bufio.NewReader(r))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+	// 6 chunks of 8 bytes, each preceded by 3 empty reads
+	assert.True(t, r.calls <= 24, "Read calls: got %d", r.calls)
+}
+
+func TestMaxHeaderSize(t *testing.T) {
+	assert.Equal(t, 65551, MaxHeaderSize())
+
+	// largest possible header should fit
+	data, err := HeaderV2{TLVs: []TLV{{Type: PP2TypeNOOP, Value: make([]byte, 0xffff-3)}}}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, MaxHeaderSize())
+
+	assert.Equal(t, MaxHeaderSize(), ParseOptions{}.MaxHeaderSize())
+	assert.Equal(t, 1024, ParseOptions{MaxV2Size: 1024}.MaxHeaderSize())
+	assert.Equal(t, 107, ParseOptions{MaxV2Size: 16}.MaxHeaderSize(), "V1 maximum")
+	assert.Equal(t, MaxHeaderSize(), ParseOptions{MaxV2Size: 1 << 20}.MaxHeaderSize())
+
+	l := NewListener(make(chanListener), 0)
+	assert.Equal(t, MaxHeaderSize(), l.MaxHeaderSize())
+	l.SetMaxV2Size(512)
+	assert.Equal(t, 512, l.MaxHeaderSize())
+}
+
+func TestParseContext(t *testing.T) {
+	const hdr = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+
+	h, err := ParseContext(context.Background(), strings.NewReader(hdr))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+	// plain reader that never sends
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = ParseContext(ctx, pr)
+	assert.Equal(t, context.Canceled, err)
+
+	// net.Conn that never sends
+	src, dst := net.Pipe()
+	defer src.Close()
+	defer dst.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ParseContext(ctx, dst)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// returned for the deadline of ctx every time, not a read timeout
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err = ParseContext(ctx, dst)
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+
+	// net.Conn cancelled without a deadline
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = ParseContext(ctx, dst)
+	assert.Equal(t, context.Canceled, err)
+
+	// deadline is cleared afterwards
+	go io.WriteString(src, hdr)
+	h, err = ParseContext(context.Background(), dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.2:5678", h.DestAddr().String())
+}
+
+func TestParseAll(t *testing.T) {
+	const (
+		outer = "PROXY TCP4 10.0.0.1 10.0.0.2 1000 2000\r\n"
+		inner = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	)
+	v2 := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1111},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 2222},
+	}
+	v2Data, err := v2.MarshalBinary()
+	assert.NoError(t, err)
+
+	check := func(name, data string, srcs []string, rest string) {
+		t.Helper()
+		r := bufio.NewReader(strings.NewReader(data))
+		hdrs, err := ParseAll(r)
+		if !assert.NoError(t, err, name) {
+			return
+		}
+		var got []string
+		for _, h := range hdrs {
+			got = append(got, h.SrcAddr().String())
+		}
+		assert.Equal(t, srcs, got, name)
+
+		remaining, err := ioutil.ReadAll(r)
+		assert.NoError(t, err, name)
+		assert.Equal(t, rest, string(remaining), name)
+	}
+
+	check("single", outer+"hello", []string{"10.0.0.1:1000"}, "hello")
+	check("single-eof", outer, []string{"10.0.0.1:1000"}, "")
+	check("double", outer+inner+"hello", []string{"10.0.0.1:1000", "192.168.0.1:1234"}, "hello")
+	check("mixed", outer+string(v2Data)+"hello", []string{"10.0.0.1:1000", "192.168.1.1:1111"}, "hello")
+
+	// data that only partially matches a signature is application data
+	check("partial-v1", outer+"PROXYING", []string{"10.0.0.1:1000"}, "PROXYING")
+	check("partial-v2", outer+"\r\n\r\nfoo", []string{"10.0.0.1:1000"}, "\r\n\r\nfoo")
+
+	// a complete signature must be followed by a valid header
+	_, err = ParseAll(bufio.NewReader(strings.NewReader(outer + "PROXY foo\r\n")))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+
+	// first header is required
+	_, err = ParseAll(bufio.NewReader(strings.NewReader("hello")))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func TestInvalidHeaderErr_Unwrap(t *testing.T) {
+	check := func(name, data string, version int, target error) {
+		t.Helper()
+		_, err := Parse(bufio.NewReader(strings.NewReader(data)))
+		var ihe *InvalidHeaderErr
+		if !assert.True(t, errors.As(err, &ihe), name) {
+			return
+		}
+		assert.Equal(t, version, ihe.Version, name)
+		if target != nil {
+			assert.True(t, errors.Is(err, target), "%s: expected %v, got %v", name, target, err)
+		}
+	}
+
+	v2 := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+	}
+	data, err := v2.MarshalBinary()
+	assert.NoError(t, err)
+
+	check("v2-truncated-preamble", string(data[:10]), 2, io.ErrUnexpectedEOF)
+	check("v2-truncated-addrs", string(data[:20]), 2, io.ErrUnexpectedEOF)
+	check("v1-eof", "PROXY TCP4 192.168.0.1", 1, io.EOF)
+	check("v1-too-long", "PROXY "+strings.Repeat("x", 200), 1, ErrHeaderTooLong)
+	check("v1-bad-port", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 99999\r\n", 1, nil)
+	check("unknown", "hello", 0, ErrNoSignature)
+}
+
+func TestParse_NoSignature(t *testing.T) {
+	check := func(name string, data []byte, opts ParseOptions) {
+		t.Helper()
+		_, err := ParseWithOptions(bufio.NewReader(bytes.NewReader(data)), opts)
+		assert.True(t, errors.Is(err, ErrNoSignature), "%s: expected ErrNoSignature, got %v", name, err)
+		assert.IsType(t, &InvalidHeaderErr{}, err, name)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		data := make([]byte, 1+rnd.Intn(200))
+		rnd.Read(data)
+		if data[0] == sigV1[0] || data[0] == sigV2[0] {
+			data[0]++
+		}
+		check(fmt.Sprintf("random %x", data), data, ParseOptions{})
+	}
+
+	// first byte matches, but not the full signature
+	check("v1-prefix", []byte("PRIORITY * HTTP/2.0\r\n"), ParseOptions{})
+	check("v1-prefix-strict", []byte("PRIORITY * HTTP/2.0\r\n"), ParseOptions{StrictV1: true})
+	check("v2-prefix", append([]byte("\r\n\r\n"), make([]byte, 20)...), ParseOptions{})
+	check("v1-long", []byte("PUT /"+strings.Repeat("x", 200)+" HTTP/1.1\r\n"), ParseOptions{})
+
+	// nothing is consumed when the signature doesn't match
+	for _, data := range []string{"PUT /" + strings.Repeat("x", 200), "\r\n\r\nGET / HTTP/1.1\r\n"} {
+		r := bufio.NewReader(strings.NewReader(data))
+		_, err := Parse(r)
+		assert.True(t, errors.Is(err, ErrNoSignature), "%q: got %v", data, err)
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, data, string(rest))
+	}
+
+	// malformed headers are not reported as missing
+	for _, data := range []string{
+		"PROXY TCP4 192.168.0.1\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.2 1234 99999\r\n",
+		string(sigV2[:8]),
+		string(sigV2) + "\x21\x11\x00\x0c\x00",
+	} {
+		_, err := Parse(bufio.NewReader(strings.NewReader(data)))
+		assert.False(t, errors.Is(err, ErrNoSignature), "%q: got %v", data, err)
+		assert.IsType(t, &InvalidHeaderErr{}, err, data)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	v2, err := HeaderV2{Command: CmdLocal}.MarshalBinary()
+	assert.NoError(t, err)
+
+	check := func(name string, data []byte, exp int) {
+		t.Helper()
+		r := bufio.NewReader(bytes.NewReader(data))
+		v, err := Detect(r)
+		assert.NoError(t, err, name)
+		assert.Equal(t, exp, v, name)
+
+		// nothing consumed
+		rest, err := ioutil.ReadAll(r)
+		assert.NoError(t, err, name)
+		assert.Equal(t, data, rest, name)
+	}
+
+	check("v1", []byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"), 1)
+	check("v2", v2, 2)
+
+	// TLS record header (handshake, TLS 1.0 record version) followed by a ClientHello
+	check("tls-client-hello", []byte{0x16, 0x03, 0x01, 0x00, 0xf1, 0x01, 0x00, 0x00, 0xed, 0x03, 0x03, 0x00, 0x00}, 0)
+	check("http", []byte("GET / HTTP/1.1\r\n\r\n"), 0)
+	check("partial-v1", []byte("PROX"), 0)
+	check("partial-v2", v2[:8], 0)
+	check("empty", []byte{}, 0)
+
+	// stops peeking once the data no longer matches, without waiting for more
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte{0x16})
+	v, err := Detect(bufio.NewReader(pr))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, v)
+}
+
+func TestParseRaw(t *testing.T) {
+	v2 := &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("example.com")},
+		},
+	}
+	v2Data, err := v2.MarshalBinary()
+	assert.NoError(t, err)
+
+	check := func(name string, hdr []byte) {
+		t.Helper()
+		r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, hdr...), "hello"...)))
+		h, raw, err := ParseRaw(r)
+		assert.NoError(t, err, name)
+		assert.NotNil(t, h, name)
+		assert.Equal(t, hdr, raw, name)
+
+		rest, err := ioutil.ReadAll(r)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "hello", string(rest), name)
+	}
+
+	check("v1", []byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"))
+	check("v1-unknown", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	check("v2-tlvs", v2Data)
+	check("v2-local", []byte(string(sigV2)+"\x20\x00\x00\x00"))
+
+	h, raw, err := ParseRaw(strings.NewReader("PROXY TCP4 192.168.0.1 bad 1234 5678\r\n"))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+	assert.Nil(t, h)
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 bad 1234 5678\r\n", string(raw))
+
+	h, raw, err = ParseRaw(bytes.NewReader(v2Data[:20]))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+	assert.Nil(t, h)
+	assert.Equal(t, v2Data[:20], raw)
+
+	_, _, err = ParseRaw(strings.NewReader("hello"))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func TestParseWithOptions_StrictV1(t *testing.T) {
+	check := func(name, data string, strictOK bool) {
+		t.Helper()
+		_, err := Parse(bufio.NewReader(strings.NewReader(data)))
+		assert.NoError(t, err, name+" lenient")
+
+		_, err = ParseWithOptions(bufio.NewReader(strings.NewReader(data)), ParseOptions{StrictV1: true})
+		if strictOK {
+			assert.NoError(t, err, name+" strict")
+		} else if assert.IsType(t, &InvalidHeaderErr{}, err, name+" strict") {
+			assert.Equal(t, 1, err.(*InvalidHeaderErr).Version, name)
+		}
+	}
+
+	check("tcp4", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n", true)
+	check("tcp6", "PROXY TCP6 2001:db8::1 2001:DB8::2 1234 5678\r\n", true)
+	check("tcp6-mapped", "PROXY TCP6 ::ffff:192.168.0.1 ::ffff:192.168.0.2 1234 5678\r\n", true)
+	check("zero-port", "PROXY TCP4 192.168.0.1 192.168.0.2 0 5678\r\n", true)
+	check("unknown", "PROXY UNKNOWN\r\n", true)
+	check("unknown-addrs", "PROXY UNKNOWN  anything  goes\r\n", true)
+
+	check("leading-zero-src-port", "PROXY TCP4 192.168.0.1 192.168.0.2 01234 5678\r\n", false)
+	check("leading-zero-dst-port", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 05678\r\n", false)
+	check("double-space", "PROXY TCP4  192.168.0.1 192.168.0.2 1234 5678\r\n", false)
+	check("trailing-space", "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678 \r\n", false)
+	check("tcp6-ipv4", "PROXY TCP6 192.168.0.1 192.168.0.2 1234 5678\r\n", false)
+}
+
+func TestParseUnbuffered(t *testing.T) {
+	v2, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}.MarshalBinary()
+	assert.NoError(t, err)
+
+	for _, hdr := range []string{"PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n", string(v2)} {
+		src, dst := net.Pipe()
+		go func() {
+			io.WriteString(src, hdr+"hello")
+			src.Close()
+		}()
+
+		h, err := ParseUnbuffered(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+		// application data is still available from the raw conn
+		data, err := ioutil.ReadAll(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+		dst.Close()
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	const v1 = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	v2, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5678},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}.MarshalBinary()
+	assert.NoError(t, err)
+
+	for _, hdr := range []string{v1, string(v2)} {
+		for _, data := range []string{"", "hello", strings.Repeat("x", 8192)} {
+			b := []byte(hdr + data)
+			h, n, err := ParseBytes(b)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+			assert.Equal(t, len(hdr), n)
+			assert.Equal(t, data, string(b[n:]))
+		}
+
+		_, n, err := ParseBytes([]byte(hdr[:len(hdr)-1]))
+		assert.IsType(t, &InvalidHeaderErr{}, err)
+		assert.Equal(t, 0, n)
+	}
+
+	_, _, err = ParseBytes(nil)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = ParseBytes([]byte("hello"))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func TestParseLimited(t *testing.T) {
+	const v1 = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n"
+	h, err := ParseLimited(strings.NewReader(v1+"hello"), 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+	h, err = ParseLimited(strings.NewReader(v1), len(v1))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:1234", h.SrcAddr().String())
+
+	isTooLong := func(name string, err error) {
+		t.Helper()
+		if assert.IsType(t, &InvalidHeaderErr{}, err, name) {
+			assert.Equal(t, ErrHeaderTooLong, err.(*InvalidHeaderErr).error, name)
+		}
+	}
+
+	_, err = ParseLimited(strings.NewReader(v1), 20)
+	isTooLong("v1", err)
+	_, err = ParseLimited(strings.NewReader(v1), 0)
+	isTooLong("zero", err)
+
+	// huge declared length is rejected without waiting for the rest
+	data := append(append([]byte{}, sigV2...), 0x21, 0x11, 0xea, 0x60) // length=60000
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(data)
+	_, err = ParseLimited(pr, 1024)
+	isTooLong("v2-declared", err)
+
+	// stream ending early is still reported as such
+	_, err = ParseLimited(strings.NewReader("PROXY TCP4"), 1024)
+	if assert.IsType(t, &InvalidHeaderErr{}, err) {
+		assert.Equal(t, io.EOF, err.(*InvalidHeaderErr).error)
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	var h1 HeaderV1
+	var h2 HeaderV2
+
+	const v1 = "PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\nhello"
+	r := bufio.NewReader(strings.NewReader(v1))
+	h, err := ParseInto(r, &h1, &h2)
+	assert.NoError(t, err)
+	assert.True(t, h == Header(&h1), "returns v1 struct")
+	assert.Equal(t, "192.168.0.1:1234", h1.SrcAddr().String())
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello", string(rest))
+
+	v2 := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeAuthority, Value: []byte("example.com")}},
+	}
+	data, err := v2.MarshalBinary()
+	assert.NoError(t, err)
+	h, err = ParseInto(bytes.NewReader(data), &h1, &h2)
+	assert.NoError(t, err)
+	assert.True(t, h == Header(&h2), "returns v2 struct")
+	assert.True(t, Equal(v2, h))
+
+	// all fields are overwritten
+	prevTLVs := h2.TLVs
+	h2.ComputeCRC = true
+	h, err = ParseInto(bytes.NewReader(append(append([]byte{}, sigV2...), 0x20, 0, 0, 0)), &h1, &h2)
+	assert.NoError(t, err)
+	assert.True(t, h == Header(&h2))
+	assert.Equal(t, HeaderV2{Command: CmdLocal}, h2)
+	assert.Equal(t, "example.com", string(prevTLVs[0].Value), "previous TLVs unchanged")
+
+	h, err = ParseInto(strings.NewReader("PROXY UNKNOWN\r\n"), &h1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, HeaderV1{}, h1)
+
+	// nil struct is allocated
+	h, err = ParseInto(bytes.NewReader(data), &h1, nil)
+	assert.NoError(t, err)
+	assert.True(t, Equal(v2, h))
+
+	_, err = ParseInto(strings.NewReader("hello"), &h1, &h2)
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func BenchmarkParse_V1(b *testing.B) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(data)
+		r.Reset(br)
+		_, err := Parse(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseInto_V1(b *testing.B) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.2 1234 5678\r\n")
+	br := bytes.NewReader(data)
+	r := bufio.NewReader(br)
+	var h1 HeaderV1
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(data)
+		r.Reset(br)
+		_, err := ParseInto(r, &h1, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
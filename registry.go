@@ -0,0 +1,70 @@
+package proxyprotocol
+
+import (
+	"fmt"
+	"sync"
+)
+
+type registeredTLV struct {
+	name   string
+	decode func([]byte) (interface{}, error)
+}
+
+var tlvRegistry = struct {
+	sync.RWMutex
+	m map[PP2Type]registeredTLV
+}{m: make(map[PP2Type]registeredTLV)}
+
+// RegisterTLVType registers a name and decoder for a custom (e.g. vendor-specific) TLV type.
+// The name is used by PP2Type.String, and decode by DecodeTLV.
+//
+// RegisterTLVType is safe to call from multiple goroutines, and is intended to be called during
+// initialization. It panics if decode is nil, t is one of the types defined by this package, or t
+// has already been registered.
+func RegisterTLVType(t PP2Type, name string, decode func([]byte) (interface{}, error)) {
+	if decode == nil {
+		panic("proxyprotocol: RegisterTLVType decoder is nil")
+	}
+	if builtinTLVName(t) != "" {
+		panic(fmt.Sprintf("proxyprotocol: RegisterTLVType called for predefined type %s", t))
+	}
+
+	tlvRegistry.Lock()
+	defer tlvRegistry.Unlock()
+	if reg, ok := tlvRegistry.m[t]; ok {
+		// t.String would deadlock here, use the existing name instead
+		panic(fmt.Sprintf("proxyprotocol: RegisterTLVType called twice for type 0x%02x (%s)", byte(t), reg.name))
+	}
+	tlvRegistry.m[t] = registeredTLV{name: name, decode: decode}
+}
+
+// DecodeTLV decodes the first TLV of type t in h using the decoder registered with RegisterTLVType.
+//
+// If no matching TLV exists, false is returned. An error is returned if no decoder is registered
+// for t, or the decoder fails.
+func DecodeTLV(h Header, t PP2Type) (interface{}, bool, error) {
+	tlvRegistry.RLock()
+	reg, ok := tlvRegistry.m[t]
+	tlvRegistry.RUnlock()
+	if !ok {
+		return nil, false, fmt.Errorf("no decoder registered for TLV type %s", t)
+	}
+
+	v, ok := FindTLV(h, t)
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := reg.decode(v)
+	if err != nil {
+		return nil, true, err
+	}
+	return val, true, nil
+}
+
+// registeredTLVName returns the name registered for t, if any.
+func registeredTLVName(t PP2Type) (string, bool) {
+	tlvRegistry.RLock()
+	reg, ok := tlvRegistry.m[t]
+	tlvRegistry.RUnlock()
+	return reg.name, ok
+}
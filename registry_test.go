@@ -0,0 +1,86 @@
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unregisterTLVType(t PP2Type) {
+	tlvRegistry.Lock()
+	delete(tlvRegistry.m, t)
+	tlvRegistry.Unlock()
+}
+
+func TestRegisterTLVType(t *testing.T) {
+	const typ = PP2Type(0xE1)
+	defer unregisterTLVType(typ)
+
+	decode := func(b []byte) (interface{}, error) {
+		if len(b) != 2 {
+			return nil, errors.New("bad length")
+		}
+		return binary.BigEndian.Uint16(b), nil
+	}
+
+	h := &HeaderV2{}
+	_, _, err := DecodeTLV(h, typ)
+	assert.Error(t, err, "unregistered")
+	assert.Equal(t, "PP2Type(0xe1)", typ.String())
+
+	RegisterTLVType(typ, "Vendor.Port", decode)
+	assert.Equal(t, "Vendor.Port", typ.String())
+
+	_, ok, err := DecodeTLV(h, typ)
+	assert.NoError(t, err)
+	assert.False(t, ok, "missing")
+
+	h.TLVs = []TLV{{Type: typ, Value: []byte{0x01, 0xbb}}}
+	v, ok, err := DecodeTLV(h, typ)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(443), v)
+
+	h.TLVs = []TLV{{Type: typ, Value: []byte{0x01}}}
+	_, ok, err = DecodeTLV(h, typ)
+	assert.EqualError(t, err, "bad length")
+	assert.True(t, ok)
+
+	assert.Panics(t, func() { RegisterTLVType(typ, "Other", decode) }, "duplicate")
+	assert.Equal(t, "Vendor.Port", typ.String(), "duplicate must not replace")
+
+	assert.Panics(t, func() { RegisterTLVType(PP2TypeAWS, "AWS2", decode) }, "predefined")
+	assert.Panics(t, func() { RegisterTLVType(0xE2, "Nil", nil) }, "nil decoder")
+	assert.Equal(t, "PP2Type(0xe2)", PP2Type(0xE2).String())
+}
+
+func TestRegisterTLVType_Concurrent(t *testing.T) {
+	types := []PP2Type{0xE3, 0xE4, 0xE5, 0xE6}
+	for _, typ := range types {
+		defer unregisterTLVType(typ)
+	}
+	decode := func(b []byte) (interface{}, error) { return string(b), nil }
+	h := &HeaderV2{TLVs: []TLV{{Type: 0xE3, Value: []byte("foo")}}}
+
+	var wg sync.WaitGroup
+	for _, typ := range types {
+		wg.Add(2)
+		go func(typ PP2Type) {
+			defer wg.Done()
+			RegisterTLVType(typ, "Vendor", decode)
+		}(typ)
+		go func(typ PP2Type) {
+			defer wg.Done()
+			_ = typ.String()
+			DecodeTLV(h, typ)
+		}(typ)
+	}
+	wg.Wait()
+
+	for _, typ := range types {
+		assert.Equal(t, "Vendor", typ.String())
+	}
+}
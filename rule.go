@@ -13,4 +13,27 @@ type Rule struct {
 	// Timeout indicates the max amount of time to receive the PROXY header before
 	// terminating the connection.
 	Timeout time.Duration
+
+	// Optional makes the PROXY header optional for connections matching this rule, as with
+	// PolicyOptional, regardless of the Listener's policy. Otherwise the Listener's policy applies.
+	Optional bool
+}
+
+// NewRule returns a Rule for the subnet described by cidr (e.g. "10.0.0.0/8" or "fd00::/8")
+// with the given timeout.
+func NewRule(cidr string, timeout time.Duration) (Rule, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Subnet: n, Timeout: timeout}, nil
+}
+
+// MustNewRule is like NewRule but panics if cidr can not be parsed.
+func MustNewRule(cidr string, timeout time.Duration) Rule {
+	r, err := NewRule(cidr, timeout)
+	if err != nil {
+		panic(err)
+	}
+	return r
 }
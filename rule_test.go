@@ -0,0 +1,55 @@
+package proxyprotocol
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRule(t *testing.T) {
+	r, err := NewRule("10.1.2.3/8", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8", r.Subnet.String())
+	assert.Equal(t, time.Second, r.Timeout)
+	assert.True(t, r.Subnet.Contains(net.ParseIP("10.255.0.1")))
+
+	r, err = NewRule("fd00::/8", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::/8", r.Subnet.String())
+	assert.True(t, r.Subnet.Contains(net.ParseIP("fd12::1")))
+
+	_, err = NewRule("10.0.0.0", time.Second)
+	assert.Error(t, err)
+	_, err = NewRule("10.0.0.0/33", time.Second)
+	assert.Error(t, err)
+	_, err = NewRule("", time.Second)
+	assert.Error(t, err)
+
+	assert.Equal(t, Rule{Subnet: r.Subnet}, MustNewRule("fd00::/8", 0))
+	assert.Panics(t, func() { MustNewRule("fd00::", 0) })
+}
+
+func ExampleNewRule() {
+	nl, err := net.Listen("tcp", ":80")
+	if err != nil {
+		log.Println("ERROR: listen:", err)
+		return
+	}
+	defer nl.Close()
+
+	// Require a PROXY header from the load balancer subnets only
+	l := NewListener(nl, 0, WithFilter([]Rule{
+		MustNewRule("10.0.0.0/24", 5*time.Second),
+		MustNewRule("fd00:1::/64", 5*time.Second),
+	}))
+
+	c, err := l.Accept()
+	if err != nil {
+		log.Println("ERROR: accept:", err)
+		return
+	}
+	log.Println("New connection from:", c.RemoteAddr().String())
+}
@@ -0,0 +1,52 @@
+//go:build go1.21
+// +build go1.21
+
+package proxyprotocol
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// LogValue implements slog.LogValuer, logging the header as a group of attributes.
+func (h HeaderV1) LogValue() slog.Value {
+	fam := h.protoFam()
+	if fam == "UNKNOWN" {
+		return slog.GroupValue(
+			slog.Int("version", 1),
+			slog.String("proto", fam),
+		)
+	}
+
+	return slog.GroupValue(
+		slog.Int("version", 1),
+		slog.String("proto", fam),
+		slog.String("src", h.SrcAddr().String()),
+		slog.String("dst", h.DestAddr().String()),
+	)
+}
+
+// LogValue implements slog.LogValuer, logging the header as a group of attributes.
+//
+// Nil addresses are omitted.
+func (h HeaderV2) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("version", 2),
+		slog.String("command", h.Command.String()),
+	}
+	if h.Src != nil {
+		attrs = append(attrs, slog.String("src", h.Src.String()))
+	}
+	if h.Dest != nil {
+		attrs = append(attrs, slog.String("dst", h.Dest.String()))
+	}
+	if len(h.TLVs) > 0 {
+		types := make([]string, len(h.TLVs))
+		for i, t := range h.TLVs {
+			types[i] = fmt.Sprint(t.Type)
+		}
+		attrs = append(attrs, slog.Any("tlvs", types))
+	}
+
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,46 @@
+//go:build go1.21
+// +build go1.21
+
+package proxyprotocol
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader_LogValue(t *testing.T) {
+	check := func(name string, h Header, exp string) {
+		t.Helper()
+		var buf bytes.Buffer
+		log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))
+		log.Info("conn", "hdr", h)
+		assert.Equal(t, exp, buf.String(), name)
+	}
+
+	check("v1", HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		DestIP:   net.ParseIP("192.168.0.2"),
+		SrcPort:  1234,
+		DestPort: 5678,
+	}, "level=INFO msg=conn hdr.version=1 hdr.proto=TCP4 hdr.src=192.168.0.1:1234 hdr.dst=192.168.0.2:5678\n")
+	check("v1-unknown", HeaderV1{}, "level=INFO msg=conn hdr.version=1 hdr.proto=UNKNOWN\n")
+
+	check("v2", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}, "level=INFO msg=conn hdr.version=2 hdr.command=PROXY hdr.src=192.168.0.1:80 hdr.dst=192.168.0.2:90 hdr.tlvs=[ALPN]\n")
+	check("v2-local", HeaderV2{}, "level=INFO msg=conn hdr.version=2 hdr.command=LOCAL\n")
+}
@@ -0,0 +1,69 @@
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SSLInfo contains information decoded from a PP2TypeSSL TLV.
+type SSLInfo struct {
+	// ClientSSL indicates the client connected over SSL/TLS.
+	ClientSSL bool
+
+	// ClientCertConn indicates the client provided a certificate over the current connection.
+	ClientCertConn bool
+
+	// ClientCertSess indicates the client provided a certificate at least once over the TLS session.
+	ClientCertSess bool
+
+	// Verify is zero if the client presented a certificate and it was successfully verified.
+	Verify uint32
+
+	Version    string
+	CommonName string
+	Cipher     string
+	SigAlg     string
+	KeyAlg     string
+}
+
+// ParseSSL will decode the PP2TypeSSL TLV of h, if present.
+//
+// If h has no SSL TLV, false is returned with a nil error.
+func ParseSSL(h Header) (*SSLInfo, bool, error) {
+	v, ok := FindTLV(h, PP2TypeSSL)
+	if !ok {
+		return nil, false, nil
+	}
+	if len(v) < 5 {
+		return nil, true, errors.New("SSL TLV too short")
+	}
+
+	info := &SSLInfo{
+		ClientSSL:      v[0]&0x01 != 0,
+		ClientCertConn: v[0]&0x02 != 0,
+		ClientCertSess: v[0]&0x04 != 0,
+		Verify:         binary.BigEndian.Uint32(v[1:]),
+	}
+
+	sub, err := ParseTLVs(v[5:])
+	if err != nil {
+		return nil, true, fmt.Errorf("SSL sub-TLVs: %w", err)
+	}
+	for _, t := range sub {
+		switch t.Type {
+		case PP2SubTypeSSLVersion:
+			info.Version = string(t.Value)
+		case PP2SubTypeSSLCN:
+			info.CommonName = string(t.Value)
+		case PP2SubTypeSSLCipher:
+			info.Cipher = string(t.Value)
+		case PP2SubTypeSSLSigAlg:
+			info.SigAlg = string(t.Value)
+		case PP2SubTypeSSLKeyAlg:
+			info.KeyAlg = string(t.Value)
+		}
+	}
+
+	return info, true, nil
+}
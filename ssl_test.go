@@ -0,0 +1,44 @@
+package proxyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSSL(t *testing.T) {
+	value := []byte{
+		0x05,       // CLIENT_SSL | CLIENT_CERT_SESS
+		0, 0, 0, 0, // verify
+		0x21, 0, 7, 'T', 'L', 'S', 'v', '1', '.', '3',
+		0x22, 0, 3, 'f', 'o', 'o',
+		0x23, 0, 6, 'A', 'E', 'S', '2', '5', '6',
+		0x24, 0, 6, 'S', 'H', 'A', '2', '5', '6',
+		0x25, 0, 7, 'R', 'S', 'A', '2', '0', '4', '8',
+	}
+	h := &HeaderV2{TLVs: []TLV{{Type: PP2TypeSSL, Value: value}}}
+
+	info, ok, err := ParseSSL(h)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &SSLInfo{
+		ClientSSL:      true,
+		ClientCertSess: true,
+		Version:        "TLSv1.3",
+		CommonName:     "foo",
+		Cipher:         "AES256",
+		SigAlg:         "SHA256",
+		KeyAlg:         "RSA2048",
+	}, info)
+
+	_, ok, err = ParseSSL(&HeaderV2{})
+	assert.NoError(t, err)
+	assert.False(t, ok, "missing")
+
+	_, ok, err = ParseSSL(&HeaderV2{TLVs: []TLV{{Type: PP2TypeSSL, Value: []byte{1, 0, 0}}}})
+	assert.Error(t, err, "short")
+	assert.True(t, ok)
+
+	_, _, err = ParseSSL(&HeaderV2{TLVs: []TLV{{Type: PP2TypeSSL, Value: []byte{1, 0, 0, 0, 0, 0x21, 0, 9, 'x'}}}})
+	assert.Error(t, err, "bad sub-TLV length")
+}
@@ -0,0 +1,404 @@
+package proxyprotocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PP2Type is the type of a PROXY protocol version 2 TLV (Type-Length-Value) field.
+type PP2Type byte
+
+// Registered PP2Type values from the PROXY protocol specification.
+const (
+	PP2TypeALPN      PP2Type = 0x01
+	PP2TypeAuthority PP2Type = 0x02
+	PP2TypeCRC32C    PP2Type = 0x03
+	PP2TypeNOOP      PP2Type = 0x04
+	PP2TypeUniqueID  PP2Type = 0x05
+	PP2TypeSSL       PP2Type = 0x20
+	PP2TypeNetNS     PP2Type = 0x30
+
+	PP2SubTypeSSLVersion PP2Type = 0x21
+	PP2SubTypeSSLCN      PP2Type = 0x22
+	PP2SubTypeSSLCipher  PP2Type = 0x23
+	PP2SubTypeSSLSigAlg  PP2Type = 0x24
+	PP2SubTypeSSLKeyAlg  PP2Type = 0x25
+)
+
+// String returns the name of known or registered types (e.g. "ALPN" or "SSL.Version"), otherwise the hex value.
+func (t PP2Type) String() string {
+	if name := builtinTLVName(t); name != "" {
+		return name
+	}
+	if name, ok := registeredTLVName(t); ok {
+		return name
+	}
+	return fmt.Sprintf("PP2Type(0x%02x)", byte(t))
+}
+
+// builtinTLVName returns the name of types defined by this package, or an empty string.
+func builtinTLVName(t PP2Type) string {
+	switch t {
+	case PP2TypeALPN:
+		return "ALPN"
+	case PP2TypeAuthority:
+		return "Authority"
+	case PP2TypeCRC32C:
+		return "CRC32C"
+	case PP2TypeNOOP:
+		return "NOOP"
+	case PP2TypeUniqueID:
+		return "UniqueID"
+	case PP2TypeSSL:
+		return "SSL"
+	case PP2TypeNetNS:
+		return "NetNS"
+	case PP2SubTypeSSLVersion:
+		return "SSL.Version"
+	case PP2SubTypeSSLCN:
+		return "SSL.CN"
+	case PP2SubTypeSSLCipher:
+		return "SSL.Cipher"
+	case PP2SubTypeSSLSigAlg:
+		return "SSL.SigAlg"
+	case PP2SubTypeSSLKeyAlg:
+		return "SSL.KeyAlg"
+	case PP2TypeAWS:
+		return "AWS"
+	case PP2TypeAzure:
+		return "Azure"
+	}
+	return ""
+}
+
+// TLV is a single Type-Length-Value field of a V2 header.
+type TLV struct {
+	Type  PP2Type
+	Value []byte
+}
+
+// StreamTLV is a TLV whose value is read from R when the header is written
+// with WriteStreaming. Len must be the exact number of bytes R will provide.
+type StreamTLV struct {
+	Type PP2Type
+	Len  int
+	R    io.Reader
+}
+
+// TLVWriter writes TLVs directly to an underlying io.Writer without buffering them,
+// tracking the total number of bytes written.
+//
+// It is intended for writing TLVs following a V2 header written separately, where the
+// header length has been computed in advance (e.g. with TLVLen).
+type TLVWriter struct {
+	w   io.Writer
+	n   int
+	hdr [3]byte
+}
+
+// NewTLVWriter will create a new TLVWriter writing to w.
+func NewTLVWriter(w io.Writer) *TLVWriter { return &TLVWriter{w: w} }
+
+// WriteTLV will write a single TLV of type t with value v.
+//
+// An error is returned if v is longer than 65535 bytes, or the underlying write fails.
+func (tw *TLVWriter) WriteTLV(t PP2Type, v []byte) error {
+	if len(v) > 0xffff {
+		return errors.New("TLV value too long")
+	}
+	tw.hdr[0] = byte(t)
+	binary.BigEndian.PutUint16(tw.hdr[1:], uint16(len(v)))
+	n, err := tw.w.Write(tw.hdr[:])
+	tw.n += n
+	if err != nil {
+		return err
+	}
+	n, err = tw.w.Write(v)
+	tw.n += n
+	return err
+}
+
+// Len returns the number of bytes written so far, including partial writes.
+func (tw *TLVWriter) Len() int { return tw.n }
+
+// TLVLen returns the number of bytes needed to encode tlvs.
+func TLVLen(tlvs []TLV) int {
+	var n int
+	for _, t := range tlvs {
+		n += 3 + len(t.Value)
+	}
+	return n
+}
+
+// TLVLengthErr is returned when a TLV declares a value length that overruns the remaining bytes.
+//
+// It wraps io.ErrUnexpectedEOF.
+type TLVLengthErr struct {
+	// Type is the type of the offending TLV.
+	Type PP2Type
+
+	// Offset is the position of the offending TLV within the TLV data.
+	Offset int
+
+	// Len is the declared value length and Remaining is the number of value bytes that were available.
+	Len, Remaining int
+}
+
+func (e *TLVLengthErr) Error() string {
+	return fmt.Sprintf("TLV type 0x%02x at offset %d: length %d exceeds remaining %d bytes", byte(e.Type), e.Offset, e.Len, e.Remaining)
+}
+
+// Unwrap always returns io.ErrUnexpectedEOF.
+func (e *TLVLengthErr) Unwrap() error { return io.ErrUnexpectedEOF }
+
+// ParseTLVs will parse all TLVs contained in b. Each TLV value is copied and does not reference b.
+//
+// If a TLV value overruns the end of b, a *TLVLengthErr is returned. If b ends
+// partway through a TLV's type or length, io.ErrUnexpectedEOF is returned.
+func ParseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	err := iterTLV(b, func(_ int, t PP2Type, v []byte) bool {
+		tlvs = append(tlvs, TLV{
+			Type:  t,
+			Value: append([]byte(nil), v...),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tlvs, nil
+}
+
+// TLVOffset is a TLV along with its position in a raw V2 header.
+type TLVOffset struct {
+	TLV
+
+	// Offset is the position of the TLV's type byte from the start of the header.
+	// The value begins at Offset+3.
+	Offset int
+}
+
+// ParseTLVOffsets will parse the TLVs of the complete raw V2 header hdr, recording the offset of each.
+//
+// This allows TLVs to be patched in place (e.g. zeroing a PP2TypeCRC32C value) without re-serializing the header.
+func ParseTLVOffsets(hdr []byte) ([]TLVOffset, error) {
+	if len(hdr) < 16 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(hdr[:12], sigV2) {
+		return nil, ErrNoSignature
+	}
+	addrLen, ok := addrLenV2(hdr[13])
+	if !ok {
+		return nil, errors.New("invalid v2 address family")
+	}
+	l := 16 + int(binary.BigEndian.Uint16(hdr[14:]))
+	if l < 16+addrLen {
+		return nil, errors.New("invalid length")
+	}
+	if len(hdr) < l {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var tlvs []TLVOffset
+	base := 16 + addrLen
+	err := iterTLV(hdr[base:l], func(off int, t PP2Type, v []byte) bool {
+		tlvs = append(tlvs, TLVOffset{
+			TLV:    TLV{Type: t, Value: append([]byte(nil), v...)},
+			Offset: base + off,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tlvs, nil
+}
+
+// IterTLV calls fn for each TLV in b, stopping if fn returns false. Unlike ParseTLVs, no memory is allocated.
+//
+// The value passed to fn references b and must be copied if it is retained after fn returns.
+// Errors are the same as ParseTLVs, but are only returned once the malformed TLV is reached.
+func IterTLV(b []byte, fn func(t PP2Type, v []byte) bool) error {
+	return iterTLV(b, func(_ int, t PP2Type, v []byte) bool { return fn(t, v) })
+}
+
+// iterTLV calls fn for each TLV in b with its offset, type, and value (referencing b), stopping if fn returns false.
+func iterTLV(b []byte, fn func(off int, t PP2Type, v []byte) bool) error {
+	var off int
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		l := int(binary.BigEndian.Uint16(b[1:]))
+		if len(b) < 3+l {
+			return &TLVLengthErr{Type: PP2Type(b[0]), Offset: off, Len: l, Remaining: len(b) - 3}
+		}
+		if !fn(off, PP2Type(b[0]), b[3:3+l]) {
+			return nil
+		}
+		b = b[3+l:]
+		off += 3 + l
+	}
+	return nil
+}
+
+// FindTLV returns the value of the first TLV of type t in h.
+//
+// If h is not a V2 header, or no matching TLV exists, false is returned.
+func FindTLV(h Header, t PP2Type) ([]byte, bool) {
+	for _, tlv := range headerTLVs(h) {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// FindAllTLV returns the values of every TLV of type t in h, in order.
+//
+// If h is not a V2 header, or no matching TLV exists, nil is returned.
+func FindAllTLV(h Header, t PP2Type) [][]byte {
+	var values [][]byte
+	for _, tlv := range headerTLVs(h) {
+		if tlv.Type == t {
+			values = append(values, tlv.Value)
+		}
+	}
+	return values
+}
+
+// headerTLVs returns the TLVs of h if it is a HeaderV2 or *HeaderV2.
+func headerTLVs(h Header) []TLV {
+	switch h := h.(type) {
+	case HeaderV2:
+		return h.TLVs
+	case *HeaderV2:
+		if h == nil {
+			return nil
+		}
+		return h.TLVs
+	}
+	return nil
+}
+
+// MergeTLVs will append extra to the TLVs of h.
+//
+// For each type listed in replace that also appears in extra, existing TLVs of that type are
+// removed first, so the values from extra take their place. All other existing TLVs are kept.
+func (h *HeaderV2) MergeTLVs(extra []TLV, replace ...PP2Type) {
+	drop := make(map[PP2Type]bool, len(replace))
+	for _, t := range replace {
+		for _, e := range extra {
+			if e.Type == t {
+				drop[t] = true
+				break
+			}
+		}
+	}
+
+	tlvs := make([]TLV, 0, len(h.TLVs)+len(extra))
+	for _, t := range h.TLVs {
+		if drop[t.Type] {
+			continue
+		}
+		tlvs = append(tlvs, t)
+	}
+	h.TLVs = append(tlvs, extra...)
+}
+
+// ALPN returns the value of the PP2TypeALPN TLV of h (e.g. "h2" or "http/1.1").
+//
+// The value is the raw protocol name as negotiated; it is not NUL-terminated.
+func ALPN(h Header) (string, bool) {
+	v, ok := FindTLV(h, PP2TypeALPN)
+	return string(v), ok
+}
+
+// ALPN returns the value of the PP2TypeALPN TLV (e.g. "h2" or "http/1.1").
+//
+// The value is the raw protocol name as negotiated; it is not NUL-terminated.
+func (h HeaderV2) ALPN() (string, bool) { return ALPN(h) }
+
+// Authority returns the value of the PP2TypeAuthority TLV, typically the host name
+// requested by the client (e.g. via SNI).
+func (h HeaderV2) Authority() (string, bool) {
+	v, ok := FindTLV(h, PP2TypeAuthority)
+	return string(v), ok
+}
+
+// SetAuthority will set the PP2TypeAuthority TLV to host, replacing any existing Authority TLV.
+//
+// An error is returned if host is longer than 65535 bytes.
+func (h *HeaderV2) SetAuthority(host string) error {
+	return h.SetTLV(PP2TypeAuthority, []byte(host))
+}
+
+// NetNS returns the value of the PP2TypeNetNS TLV, the name of the network namespace
+// the connection was received in.
+func (h HeaderV2) NetNS() (string, bool) {
+	v, ok := FindTLV(h, PP2TypeNetNS)
+	return string(v), ok
+}
+
+// SetNetNS will set the PP2TypeNetNS TLV to name, replacing any existing NetNS TLV.
+//
+// An error is returned if name is longer than 65535 bytes.
+func (h *HeaderV2) SetNetNS(name string) error {
+	return h.SetTLV(PP2TypeNetNS, []byte(name))
+}
+
+// UniqueID returns the value of the PP2TypeUniqueID TLV, an opaque identifier for the connection.
+func (h HeaderV2) UniqueID() ([]byte, bool) { return FindTLV(h, PP2TypeUniqueID) }
+
+// SetUniqueID will set the PP2TypeUniqueID TLV to id, replacing any existing UniqueID TLV.
+//
+// An error is returned if id is longer than 128 bytes, the maximum allowed by the specification.
+func (h *HeaderV2) SetUniqueID(id []byte) error {
+	if len(id) > 128 {
+		return errors.New("unique ID too long")
+	}
+	return h.SetTLV(PP2TypeUniqueID, id)
+}
+
+// AddTLV will append a TLV of type t with value v.
+//
+// An error is returned if v is longer than 65535 bytes.
+func (h *HeaderV2) AddTLV(t PP2Type, v []byte) error {
+	if len(v) > 0xffff {
+		return errors.New("TLV value too long")
+	}
+	h.TLVs = append(h.TLVs, TLV{Type: t, Value: v})
+	return nil
+}
+
+// SetTLV will replace the first TLV of type t with value v, removing any others, or append it if none exist.
+//
+// An error is returned if v is longer than 65535 bytes.
+func (h *HeaderV2) SetTLV(t PP2Type, v []byte) error {
+	if len(v) > 0xffff {
+		return errors.New("TLV value too long")
+	}
+	found := false
+	tlvs := make([]TLV, 0, len(h.TLVs)+1)
+	for _, tlv := range h.TLVs {
+		if tlv.Type != t {
+			tlvs = append(tlvs, tlv)
+			continue
+		}
+		if found {
+			continue
+		}
+		found = true
+		tlvs = append(tlvs, TLV{Type: t, Value: v})
+	}
+	if !found {
+		tlvs = append(tlvs, TLV{Type: t, Value: v})
+	}
+	h.TLVs = tlvs
+	return nil
+}
@@ -0,0 +1,431 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLVs(t *testing.T) {
+	tlvs, err := ParseTLVs([]byte{
+		0x01, 0x00, 0x02, 'h', '2',
+		0x04, 0x00, 0x00,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []TLV{
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: PP2TypeNOOP, Value: nil},
+	}, tlvs)
+
+	_, err = ParseTLVs([]byte{0x01, 0x00})
+	assert.Equal(t, io.ErrUnexpectedEOF, err, "short type/length")
+
+	_, err = ParseTLVs([]byte{
+		0x01, 0x00, 0x02, 'h', '2',
+		0x02, 0x00, 0x05, 'f', 'o', 'o',
+	})
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "short value")
+	var lenErr *TLVLengthErr
+	if assert.True(t, errors.As(err, &lenErr), "TLVLengthErr") {
+		assert.Equal(t, PP2TypeAuthority, lenErr.Type)
+		assert.Equal(t, 5, lenErr.Offset)
+		assert.Equal(t, 5, lenErr.Len)
+		assert.Equal(t, 3, lenErr.Remaining)
+		assert.Contains(t, lenErr.Error(), "type 0x02 at offset 5")
+	}
+}
+
+func TestIterTLV(t *testing.T) {
+	b := []byte{
+		0x01, 0x00, 0x02, 'h', '2',
+		0x04, 0x00, 0x00,
+		0x02, 0x00, 0x05, 'f', 'o', 'o',
+	}
+
+	var types []PP2Type
+	err := IterTLV(b, func(t PP2Type, v []byte) bool {
+		types = append(types, t)
+		return t != PP2TypeNOOP
+	})
+	assert.NoError(t, err, "stopped before malformed TLV")
+	assert.Equal(t, []PP2Type{PP2TypeALPN, PP2TypeNOOP}, types)
+
+	err = IterTLV(b, func(t PP2Type, v []byte) bool {
+		if t == PP2TypeALPN {
+			v[0] = 'H' // aliases b
+		}
+		return true
+	})
+	assert.IsType(t, &TLVLengthErr{}, err)
+	assert.Equal(t, byte('H'), b[3])
+}
+
+func BenchmarkIterTLV(b *testing.B) {
+	data := []byte{
+		0x01, 0x00, 0x02, 'h', '2',
+		0x02, 0x00, 0x0b, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm',
+		0x04, 0x00, 0x04, 0, 0, 0, 0,
+	}
+	b.Run("IterTLV", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			IterTLV(data, func(t PP2Type, v []byte) bool { return t != PP2TypeAuthority })
+		}
+	})
+	b.Run("ParseTLVs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ParseTLVs(data)
+		}
+	})
+}
+
+func TestHeaderV2_TLVs(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("example.com")},
+		},
+	}
+
+	var buf bytes.Buffer
+	_, err := h.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 12 + 5 + 14}, buf.Bytes()[14:16], "Length")
+
+	p, err := Parse(bufio.NewReader(&buf))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, h.TLVs, p.(*HeaderV2).TLVs)
+
+	v, ok := FindTLV(p, PP2TypeAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(v))
+
+	_, ok = FindTLV(p, PP2TypeNetNS)
+	assert.False(t, ok)
+	_, ok = FindTLV(&HeaderV1{}, PP2TypeALPN)
+	assert.False(t, ok)
+}
+
+func TestParse_TLVs(t *testing.T) {
+	sample := append([]byte{}, sigV2...)
+	sample = append(sample,
+		0x21, 0x11, 0, 12+7,
+		127, 0, 0, 1, 127, 0, 0, 2, 0x04, 0xd2, 0x16, 0x2e,
+		0x04, 0x00, 0x04, 0, 0, 0, 0,
+	)
+
+	h, err := Parse(bufio.NewReader(bytes.NewReader(sample)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	v, ok := FindTLV(h, PP2TypeNOOP)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0, 0, 0, 0}, v)
+
+	// raw bytes are kept, and not shared with the input or the TLVs
+	trailing := h.(*HeaderV2).Trailing
+	assert.Equal(t, sample[16+12:], trailing)
+	sample[len(sample)-1] = 1
+	v[0] = 1
+	assert.Equal(t, []byte{0x04, 0x00, 0x04, 0, 0, 0, 0}, trailing)
+
+	// ignored when writing
+	h.(*HeaderV2).Trailing = []byte("junk")
+	data, err := h.(*HeaderV2).MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, len(sample))
+
+	// no data after the addresses
+	noTLVs := append([]byte{}, sample[:16+12]...)
+	noTLVs[15] = 12
+	h, err = Parse(bufio.NewReader(bytes.NewReader(noTLVs)))
+	if assert.NoError(t, err) {
+		assert.Nil(t, h.(*HeaderV2).Trailing)
+	}
+
+	// value runs past the end of the header
+	sample[len(sample)-5] = 0x05
+	_, err = Parse(bufio.NewReader(bytes.NewReader(sample)))
+	assert.IsType(t, &InvalidHeaderErr{}, err)
+}
+
+func TestHeaderV2_WriteStreaming(t *testing.T) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}},
+	}
+	value := bytes.Repeat([]byte("0123456789"), 4000)
+
+	var buf bytes.Buffer
+	n, err := h.WriteStreaming(&buf, StreamTLV{Type: PP2TypeSSL, Len: len(value), R: bytes.NewReader(value)})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 16+12+5+3+len(value), n)
+	assert.EqualValues(t, buf.Len(), n)
+	assert.Equal(t, []byte{0x9c, 0x54}, buf.Bytes()[14:16], "Length") // 12+5+3+40000
+	assert.Equal(t, []byte{0x20, 0x9c, 0x40}, buf.Bytes()[33:36], "Stream TLV Type/Length")
+
+	p, err := Parse(bufio.NewReader(&buf))
+	if !assert.NoError(t, err) {
+		return
+	}
+	v, ok := FindTLV(p, PP2TypeSSL)
+	assert.True(t, ok)
+	assert.Equal(t, value, v)
+
+	_, err = h.WriteStreaming(ioutil.Discard, StreamTLV{Type: PP2TypeSSL, Len: 10, R: bytes.NewReader([]byte("short"))})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestHeaderV2_MergeTLVs(t *testing.T) {
+	orig := []TLV{
+		{Type: PP2TypeAuthority, Value: []byte("a.example.com")},
+		{Type: PP2TypeUniqueID, Value: []byte("id")},
+	}
+
+	h := HeaderV2{TLVs: orig}
+	h.MergeTLVs([]TLV{{Type: PP2TypeAuthority, Value: []byte("b.example.com")}})
+	assert.Equal(t, []TLV{
+		{Type: PP2TypeAuthority, Value: []byte("a.example.com")},
+		{Type: PP2TypeUniqueID, Value: []byte("id")},
+		{Type: PP2TypeAuthority, Value: []byte("b.example.com")},
+	}, h.TLVs, "append")
+
+	h = HeaderV2{TLVs: orig}
+	h.MergeTLVs([]TLV{{Type: PP2TypeAuthority, Value: []byte("b.example.com")}}, PP2TypeAuthority, PP2TypeUniqueID)
+	assert.Equal(t, []TLV{
+		{Type: PP2TypeUniqueID, Value: []byte("id")},
+		{Type: PP2TypeAuthority, Value: []byte("b.example.com")},
+	}, h.TLVs, "replace")
+	assert.Len(t, orig, 2, "original slice untouched")
+	assert.Equal(t, PP2TypeAuthority, orig[0].Type, "original slice untouched")
+}
+
+func TestALPN(t *testing.T) {
+	h := HeaderV2{TLVs: []TLV{{Type: PP2TypeALPN, Value: []byte("http/1.1")}}}
+	v, ok := h.ALPN()
+	assert.True(t, ok)
+	assert.Equal(t, "http/1.1", v)
+
+	v, ok = ALPN(&h)
+	assert.True(t, ok)
+	assert.Equal(t, "http/1.1", v)
+
+	_, ok = HeaderV2{}.ALPN()
+	assert.False(t, ok)
+	_, ok = ALPN(&HeaderV1{})
+	assert.False(t, ok)
+}
+
+func TestHeaderV2_Authority(t *testing.T) {
+	var h HeaderV2
+	_, ok := h.Authority()
+	assert.False(t, ok)
+
+	h.TLVs = []TLV{{Type: PP2TypeALPN, Value: []byte("h2")}}
+	assert.NoError(t, h.SetAuthority("a.example.com"))
+	assert.NoError(t, h.SetAuthority("b.example.com"))
+	assert.Equal(t, []TLV{
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: PP2TypeAuthority, Value: []byte("b.example.com")},
+	}, h.TLVs)
+
+	v, ok := h.Authority()
+	assert.True(t, ok)
+	assert.Equal(t, "b.example.com", v)
+
+	assert.Error(t, h.SetAuthority(strings.Repeat("a", 0x10000)))
+	v, _ = h.Authority()
+	assert.Equal(t, "b.example.com", v, "unchanged after error")
+}
+
+func TestHeaderV2_NetNS(t *testing.T) {
+	var h HeaderV2
+	_, ok := h.NetNS()
+	assert.False(t, ok)
+
+	assert.NoError(t, h.SetNetNS("blue"))
+	assert.NoError(t, h.SetNetNS("red"))
+	assert.Equal(t, []TLV{{Type: PP2TypeNetNS, Value: []byte("red")}}, h.TLVs)
+
+	v, ok := h.NetNS()
+	assert.True(t, ok)
+	assert.Equal(t, "red", v)
+
+	assert.Error(t, h.SetNetNS(strings.Repeat("a", 0x10000)))
+}
+
+func TestHeaderV2_UniqueID(t *testing.T) {
+	var h HeaderV2
+	_, ok := h.UniqueID()
+	assert.False(t, ok)
+
+	assert.NoError(t, h.SetUniqueID([]byte("trace-1")))
+	assert.NoError(t, h.SetUniqueID(bytes.Repeat([]byte{'a'}, 128)))
+	v, ok := h.UniqueID()
+	assert.True(t, ok)
+	assert.Len(t, v, 128)
+	assert.Len(t, h.TLVs, 1)
+
+	assert.Error(t, h.SetUniqueID(bytes.Repeat([]byte{'a'}, 129)))
+	v, _ = h.UniqueID()
+	assert.Len(t, v, 128, "unchanged after error")
+}
+
+func TestTLVWriter(t *testing.T) {
+	tlvs := []TLV{
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: PP2TypeNOOP},
+	}
+
+	var buf bytes.Buffer
+	tw := NewTLVWriter(&buf)
+	for _, tlv := range tlvs {
+		assert.NoError(t, tw.WriteTLV(tlv.Type, tlv.Value))
+	}
+	assert.Equal(t, TLVLen(tlvs), tw.Len())
+	assert.Equal(t, []byte{0x01, 0, 2, 'h', '2', 0x04, 0, 0}, buf.Bytes())
+
+	assert.Error(t, tw.WriteTLV(PP2TypeSSL, make([]byte, 0x10000)))
+
+	tw = NewTLVWriter(&failWriter{n: 4})
+	assert.Error(t, tw.WriteTLV(PP2TypeALPN, []byte("h2")))
+	assert.Equal(t, 4, tw.Len())
+}
+
+func benchTLVs() []TLV {
+	return []TLV{
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: PP2TypeAuthority, Value: []byte("example.com")},
+		{Type: PP2TypeSSL, Value: make([]byte, 16384)},
+	}
+}
+
+func BenchmarkHeaderV2_WriteTo_TLVs(b *testing.B) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs:    benchTLVs(),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkTLVWriter(b *testing.B) {
+	h := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+	}
+	tlvs := benchTLVs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// header with the TLV length added, followed by streamed TLVs
+		data, _ := h.MarshalBinary()
+		binary.BigEndian.PutUint16(data[14:], uint16(len(data)-16+TLVLen(tlvs)))
+		ioutil.Discard.Write(data)
+		tw := NewTLVWriter(ioutil.Discard)
+		for _, t := range tlvs {
+			tw.WriteTLV(t.Type, t.Value)
+		}
+	}
+}
+
+func TestParseTLVOffsets(t *testing.T) {
+	data, err := HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 90},
+		TLVs: []TLV{
+			{Type: PP2TypeALPN, Value: []byte("h2")},
+			{Type: PP2TypeAuthority, Value: []byte("example.com")},
+			{Type: PP2TypeCRC32C, Value: []byte{1, 2, 3, 4}},
+		},
+	}.MarshalBinary()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tlvs, err := ParseTLVOffsets(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []TLVOffset{
+		{TLV: TLV{Type: PP2TypeALPN, Value: []byte("h2")}, Offset: 28},
+		{TLV: TLV{Type: PP2TypeAuthority, Value: []byte("example.com")}, Offset: 33},
+		// the checksum is computed when written
+		{TLV: TLV{Type: PP2TypeCRC32C, Value: data[50:54]}, Offset: 47},
+	}, tlvs)
+	for _, tlv := range tlvs {
+		assert.Equal(t, byte(tlv.Type), data[tlv.Offset])
+		assert.Equal(t, tlv.Value, data[tlv.Offset+3:tlv.Offset+3+len(tlv.Value)])
+	}
+
+	_, err = ParseTLVOffsets(data[:40])
+	assert.Equal(t, io.ErrUnexpectedEOF, err, "truncated")
+
+	_, err = ParseTLVOffsets(append([]byte("GET / HTTP/1.1\r\n"), data[16:]...))
+	assert.Equal(t, ErrNoSignature, err)
+}
+
+func TestFindAllTLV(t *testing.T) {
+	h := HeaderV2{TLVs: []TLV{
+		{Type: 0xE0, Value: []byte("a")},
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: 0xE0, Value: []byte("b")},
+	}}
+
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, FindAllTLV(h, 0xE0))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, FindAllTLV(&h, 0xE0))
+	assert.Nil(t, FindAllTLV(h, PP2TypeNOOP))
+	assert.Nil(t, FindAllTLV(&HeaderV1{}, 0xE0))
+}
+
+func TestHeaderV2_AddTLV_SetTLV(t *testing.T) {
+	var h HeaderV2
+	assert.NoError(t, h.AddTLV(0xE0, []byte("a")))
+	assert.NoError(t, h.AddTLV(PP2TypeALPN, []byte("h2")))
+	assert.NoError(t, h.AddTLV(0xE0, []byte("b")))
+	assert.Error(t, h.AddTLV(0xE0, make([]byte, 0x10000)))
+	assert.Len(t, h.TLVs, 3)
+
+	assert.NoError(t, h.SetTLV(0xE0, []byte("c")))
+	assert.Equal(t, []TLV{
+		{Type: 0xE0, Value: []byte("c")},
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+	}, h.TLVs)
+
+	assert.NoError(t, h.SetTLV(PP2TypeNOOP, nil))
+	assert.Equal(t, PP2TypeNOOP, h.TLVs[2].Type)
+	assert.Error(t, h.SetTLV(0xE0, make([]byte, 0x10000)))
+	assert.Len(t, h.TLVs, 3)
+}
+
+func TestPP2Type_String(t *testing.T) {
+	assert.Equal(t, "ALPN", PP2TypeALPN.String())
+	assert.Equal(t, "Authority", PP2TypeAuthority.String())
+	assert.Equal(t, "CRC32C", PP2TypeCRC32C.String())
+	assert.Equal(t, "NOOP", PP2TypeNOOP.String())
+	assert.Equal(t, "UniqueID", PP2TypeUniqueID.String())
+	assert.Equal(t, "SSL", PP2TypeSSL.String())
+	assert.Equal(t, "SSL.Version", PP2SubTypeSSLVersion.String())
+	assert.Equal(t, "SSL.KeyAlg", PP2SubTypeSSLKeyAlg.String())
+	assert.Equal(t, "NetNS", PP2TypeNetNS.String())
+	assert.Equal(t, "PP2Type(0xe0)", PP2Type(0xE0).String())
+}
@@ -0,0 +1,48 @@
+package proxyprotocol
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// NewTransport returns a copy of base that sends a PROXY header on each new connection, before any
+// other data (including a TLS handshake). If base is nil, http.DefaultTransport is used.
+//
+// The header function is called with the address being dialed (e.g. "example.com:443") and
+// returns the header to send, or nil to send none. The header is written with the dial context's
+// deadline, if any. If base has a DialContext function, it is used to establish connections.
+//
+// Since connections are reused, the header is only sent once per connection rather than per
+// request. Set DisableKeepAlives on base if each request needs its own header.
+func NewTransport(base *http.Transport, header func(addr string) Header) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+
+	dial := base.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		hdr := header(addr)
+		if hdr == nil {
+			return c, nil
+		}
+
+		deadline, _ := ctx.Deadline()
+		_, err = WriteHeader(c, hdr, deadline)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return t
+}
@@ -0,0 +1,91 @@
+package proxyprotocol
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRemoteAddrServer() *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.RemoteAddr)
+	}))
+	srv.Listener = NewListener(srv.Listener, time.Second)
+	srv.Start()
+	return srv
+}
+
+func ExampleNewTransport() {
+	srv := newRemoteAddrServer()
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: NewTransport(nil, func(addr string) Header {
+			return &HeaderV1{
+				SrcIP:    net.ParseIP("192.168.0.1"),
+				SrcPort:  1234,
+				DestIP:   net.ParseIP("192.168.0.2"),
+				DestPort: 443,
+			}
+		}),
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(data))
+	// Output: 192.168.0.1:1234
+}
+
+func TestNewTransport(t *testing.T) {
+	srv := newRemoteAddrServer()
+	defer srv.Close()
+
+	var dialed []string
+	base := &http.Transport{DisableKeepAlives: true}
+	tr := NewTransport(base, func(addr string) Header {
+		dialed = append(dialed, addr)
+		return &HeaderV2{
+			Command: CmdProxy,
+			Src:     &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000 + len(dialed)},
+			Dest:    &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80},
+		}
+	})
+	assert.True(t, tr != base, "base must be copied")
+	assert.Nil(t, base.DialContext, "base must not be modified")
+	client := &http.Client{Transport: tr}
+
+	get := func() string {
+		t.Helper()
+		resp, err := client.Get(srv.URL)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "10.0.0.1:5001", get())
+	assert.Equal(t, "10.0.0.1:5002", get())
+	assert.Equal(t, []string{srv.Listener.Addr().String(), srv.Listener.Addr().String()}, dialed)
+
+	// nil header sends nothing, which the server rejects
+	client.Transport = NewTransport(base, func(string) Header { return nil })
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,50 @@
+package proxyprotocol
+
+import "fmt"
+
+// A Validator checks a parsed header, returning a non-nil error if it should be rejected.
+type Validator func(Header) error
+
+// ValidateHeader will run each Validator against h in order, returning the first error.
+//
+// To reject connections with invalid headers, use a Listener with SetValidators instead.
+func ValidateHeader(h Header, v ...Validator) error {
+	for _, fn := range v {
+		err := fn(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpectDestPort returns a Validator that rejects headers with a destination port other than port.
+//
+// Headers without a destination address (i.e. LOCAL commands or UNKNOWN V1 headers) are accepted
+// since the real connection endpoints will be used. Headers with a non-TCP/UDP destination (e.g. UNIX) are rejected.
+func ExpectDestPort(port int) Validator {
+	return func(h Header) error {
+		switch h := h.(type) {
+		case *HeaderV1:
+			if h.protoFam() == "UNKNOWN" {
+				return nil
+			}
+		case HeaderV1:
+			if h.protoFam() == "UNKNOWN" {
+				return nil
+			}
+		}
+		dst := h.DestAddr()
+		if dst == nil {
+			return nil
+		}
+		p, ok := addrPort(dst)
+		if !ok {
+			return fmt.Errorf("destination %s: no port, expected %d", dst.String(), port)
+		}
+		if p != port {
+			return fmt.Errorf("destination port %d: expected %d", p, port)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package proxyprotocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectDestPort(t *testing.T) {
+	check := func(name string, h Header, valid bool) {
+		t.Helper()
+		err := ValidateHeader(h, ExpectDestPort(443))
+		if valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+
+	check("v1-match", &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		DestIP:   net.ParseIP("192.168.0.2"),
+		SrcPort:  1234,
+		DestPort: 443,
+	}, true)
+	check("v1-mismatch", &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		DestIP:   net.ParseIP("192.168.0.2"),
+		SrcPort:  1234,
+		DestPort: 8443,
+	}, false)
+	check("v1-unknown", &HeaderV1{}, true)
+
+	check("v2-match", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 443},
+	}, true)
+	check("v2-mismatch", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1234},
+		Dest:    &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 80},
+	}, false)
+	check("v2-local", &HeaderV2{Command: CmdLocal}, true)
+	check("v2-unix", &HeaderV2{
+		Command: CmdProxy,
+		Src:     &net.UnixAddr{Net: "unix", Name: "foo"},
+		Dest:    &net.UnixAddr{Net: "unix", Name: "bar"},
+	}, false)
+}
@@ -0,0 +1,85 @@
+package proxyprotocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+)
+
+// writeConn sends a PROXY header before any other data written to the connection.
+type writeConn struct {
+	net.Conn
+	hdr Header
+
+	mx   sync.Mutex
+	sent bool
+	err  error
+}
+
+// NewWriteConn wraps c so that h is sent before the first data written to it.
+//
+// The header is sent together with the payload of the first call to Write, so both are written with a
+// single call to c.Write. Writing an empty payload sends just the header. The returned net.Conn also
+// implements io.ReaderFrom, sending the header and then delegating to c (if it implements io.ReaderFrom),
+// so io.Copy can use fast paths such as splice or sendfile provided by the underlying connection.
+//
+// If sending the header fails, the error is returned by all further writes.
+func NewWriteConn(c net.Conn, h Header) net.Conn {
+	return &writeConn{Conn: c, hdr: h}
+}
+
+// writeHeader sends the header followed by p, if it hasn't been sent already. The returned count
+// only includes bytes of p, and sent reports whether p was written.
+func (c *writeConn) writeHeader(p []byte) (n int, sent bool, err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.err != nil {
+		return 0, true, c.err
+	}
+	if c.sent {
+		return 0, false, nil
+	}
+	c.sent = true
+
+	var buf bytes.Buffer
+	_, err = c.hdr.WriteTo(&buf)
+	if err != nil {
+		c.err = err
+		return 0, true, err
+	}
+	hdrLen := buf.Len()
+	buf.Write(p)
+
+	n, err = c.Conn.Write(buf.Bytes())
+	if n < hdrLen {
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		c.err = err
+		return 0, true, err
+	}
+	return n - hdrLen, true, err
+}
+
+func (c *writeConn) Write(p []byte) (int, error) {
+	n, sent, err := c.writeHeader(p)
+	if sent {
+		return n, err
+	}
+	return c.Conn.Write(p)
+}
+
+// writerOnly hides any io.ReaderFrom implementation of the underlying writer.
+type writerOnly struct{ io.Writer }
+
+func (c *writeConn) ReadFrom(r io.Reader) (int64, error) {
+	_, _, err := c.writeHeader(nil)
+	if err != nil {
+		return 0, err
+	}
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{c.Conn}, r)
+}
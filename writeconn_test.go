@@ -0,0 +1,101 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readerFromConn records calls to ReadFrom.
+type readerFromConn struct {
+	net.Conn
+	calls int
+}
+
+func (c *readerFromConn) ReadFrom(r io.Reader) (int64, error) {
+	c.calls++
+	return io.Copy(writerOnly{c.Conn}, r)
+}
+
+func TestNewWriteConn(t *testing.T) {
+	hdr := &HeaderV1{
+		SrcIP:    net.ParseIP("192.168.0.1"),
+		SrcPort:  1234,
+		DestIP:   net.ParseIP("192.168.0.2"),
+		DestPort: 5678,
+	}
+
+	check := func(name string, write func(c net.Conn) error) {
+		t.Helper()
+		src, dst := net.Pipe()
+		rf := &readerFromConn{Conn: src}
+		done := make(chan error, 1)
+		go func() {
+			c := NewWriteConn(rf, hdr)
+			done <- write(c)
+			c.Close()
+		}()
+
+		r := bufio.NewReader(dst)
+		h, err := Parse(r)
+		assert.NoError(t, err, name)
+		assert.True(t, Equal(hdr, h), name)
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "hello world", string(data), name)
+		assert.NoError(t, <-done, name)
+	}
+
+	check("write", func(c net.Conn) error {
+		n, err := io.WriteString(c, "hello")
+		if err == nil && n != 5 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(c, " world")
+		return err
+	})
+
+	var rf *readerFromConn
+	check("copy", func(c net.Conn) error {
+		rf = c.(*writeConn).Conn.(*readerFromConn)
+		// hide strings.Reader.WriteTo, which io.Copy would otherwise prefer
+		n, err := io.Copy(c, struct{ io.Reader }{strings.NewReader("hello world")})
+		if err == nil && n != 11 {
+			err = io.ErrShortWrite
+		}
+		return err
+	})
+	assert.Equal(t, 1, rf.calls, "ReadFrom used")
+
+	check("write-then-copy", func(c net.Conn) error {
+		_, err := io.WriteString(c, "hello")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(c, strings.NewReader(" world"))
+		return err
+	})
+}
+
+func TestNewWriteConn_Error(t *testing.T) {
+	src, dst := net.Pipe()
+	dst.Close()
+
+	c := NewWriteConn(src, &HeaderV1{})
+	_, err := c.Write([]byte("hello"))
+	assert.Error(t, err)
+
+	// sticky
+	_, err2 := c.Write([]byte("hello"))
+	assert.Equal(t, err, err2)
+	_, err2 = io.Copy(c, strings.NewReader("hello"))
+	assert.Equal(t, err, err2)
+}